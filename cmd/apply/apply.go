@@ -0,0 +1,20 @@
+package apply
+
+import (
+	"context"
+
+	apply "github.com/rerost/es-cli/cmd/apply/state"
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+)
+
+func NewApplyCommand(ctx context.Context, st domain.State) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Apply desired state",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	cmd.AddCommand(apply.NewStateCmd(ctx, st))
+	return cmd
+}
@@ -6,6 +6,9 @@ import (
 	"fmt"
 
 	"github.com/rerost/es-cli/config"
+	"github.com/rerost/es-cli/domain"
+	"github.com/rerost/es-cli/infra/es"
+	"github.com/rerost/es-cli/infra/http"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/srvc/fail"
@@ -52,8 +55,8 @@ func NewConfig() (config.Config, error) {
 	pflag.StringP("user", "u", "", "ES basic auth user")
 	pflag.StringP("pass", "p", "", "ES basic auth password")
 	pflag.BoolP("insecure", "k", false, "Same as curl insecure")
-	pflag.StringP("namespace", "n", "localhost", "Specify config in es-cli")                                       // For conf. Think alter position
-	pflag.Bool("set-include-type-name", false, `Set the API parameter "include_type_name" when creating an index`) // ref. https://www.elastic.co/guide/en/elasticsearch/reference/7.x/removal-of-types.html
+	pflag.StringP("namespace", "n", "localhost", "Specify config in es-cli")                                                     // For conf. Think alter position
+	pflag.Bool("set-include-type-name", false, `Set the API parameter "include_type_name" on 7.x even if mappings are typeless`) // ref. https://www.elastic.co/guide/en/elasticsearch/reference/7.x/removal-of-types.html
 
 	pflag.BoolP("verbose", "v", false, "")
 	pflag.BoolP("debug", "d", false, "")
@@ -61,7 +64,27 @@ func NewConfig() (config.Config, error) {
 	viper.BindPFlags(pflag.CommandLine)
 
 	var cfg config.Config
+	// Subcommands own their flags. e.g. restore index --detail. They are parsed by cobra, so they must not fail here.
+	pflag.CommandLine.ParseErrorsWhitelist.UnknownFlags = true
 	pflag.Parse()
 	err := viper.Unmarshal(&cfg)
 	return cfg, fail.Wrap(err)
 }
+
+func NewBaseClientFactory() es.BaseClientFactory {
+	return func(namespace string) (es.BaseClient, error) {
+		cfg, err := config.LoadNamespaceConfigFile(namespace)
+		if err != nil {
+			return nil, fail.Wrap(err, fail.WithParam("namespace", namespace))
+		}
+		return es.NewBaseClient(cfg, http.NewClient(cfg))
+	}
+}
+
+// ExitCode returns 2 when diff finds differences, so that CI can tell them from errors. Otherwise 1
+func ExitCode(err error) int {
+	if e := fail.Unwrap(err); e != nil && e.Code == domain.CodeDrift {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,46 @@
+package diff
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rerost/es-cli/cmd/diff/output"
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+	"github.com/srvc/fail"
+)
+
+func NewClusterCmd(ctx context.Context, cmp domain.Compare) *cobra.Command {
+	var opt domain.CompareOpt
+	var color string
+
+	cmd := &cobra.Command{
+		Use:   "cluster <namespace_a> <namespace_b>",
+		Short: "Compare index patterns, aliases, templates, pipelines and ILM policies between namespaces",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			drifts, err := cmp.Cluster(ctx, args[0], args[1], opt)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+
+			output.WriteClusterDiff(os.Stdout, args[0], args[1], drifts, output.Colored(color, os.Stdout))
+			if len(drifts) > 0 {
+				// Differences are not usage errors
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return fail.Wrap(fail.New(fmt.Sprintf("%d differences are found", len(drifts))), fail.WithCode(domain.CodeDrift))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&opt.Match, "match", nil, "Glob patterns of names to compare. e.g. orders*,logs-*")
+	cmd.Flags().BoolVar(&opt.IgnoreAliases, "ignore-aliases", false, "Do not compare aliases")
+	cmd.Flags().StringSliceVar(&opt.Ignore, "ignore", nil, "Paths not to compare. e.g. settings.index.number_of_replicas")
+	cmd.Flags().StringVar(&opt.NameTemplate, "name-template", "", "Name template of update detail. Indices named by it are compared as the same pattern. e.g. sha or {name}-{sha}")
+	cmd.Flags().StringVar(&color, "color", output.ColorAuto, "Colorize output. auto, always or never")
+
+	return cmd
+}
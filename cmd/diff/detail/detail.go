@@ -0,0 +1,57 @@
+package diff
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rerost/es-cli/cmd/diff/output"
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+	"github.com/srvc/fail"
+)
+
+func NewDetailCmd(ctx context.Context, cmp domain.Compare) *cobra.Command {
+	var opt domain.CompareOpt
+	var a, b domain.DetailSource
+	var color string
+
+	cmd := &cobra.Command{
+		Use:   "detail <index_or_alias> [<index_or_alias_b>]",
+		Short: "Compare details of indices between namespaces, in a cluster or with a detail file",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a.Index = args[0]
+			b.Index = args[0]
+			if len(args) == 2 {
+				b.Index = args[1]
+			}
+			if a == b {
+				return fail.New("Specify another index, --namespace-b or --file")
+			}
+
+			changes, err := cmp.Detail(ctx, a, b, opt)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+
+			output.WriteDetailDiff(os.Stdout, a, b, changes, output.Colored(color, os.Stdout))
+			if len(changes) > 0 {
+				// Differences are not usage errors
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return fail.Wrap(fail.New(fmt.Sprintf("%d differences are found", len(changes))), fail.WithCode(domain.CodeDrift))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&a.Namespace, "namespace-a", "", "Namespace of escli.json for the first index. Default is the cluster of options")
+	cmd.Flags().StringVar(&b.Namespace, "namespace-b", "", "Namespace of escli.json for the second index. Default is the cluster of options")
+	cmd.Flags().StringVar(&b.File, "file", "", "Detail json file to compare with the index")
+	cmd.Flags().BoolVar(&opt.IgnoreAliases, "ignore-aliases", false, "Do not compare aliases")
+	cmd.Flags().StringSliceVar(&opt.Ignore, "ignore", nil, "Paths not to compare. e.g. settings.index.number_of_replicas,mappings.properties.tmp*")
+	cmd.Flags().StringVar(&color, "color", output.ColorAuto, "Colorize output. auto, always or never")
+
+	return cmd
+}
@@ -0,0 +1,22 @@
+package diff
+
+import (
+	"context"
+
+	diffcluster "github.com/rerost/es-cli/cmd/diff/cluster"
+	diff "github.com/rerost/es-cli/cmd/diff/detail"
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+)
+
+func NewDiffCommand(ctx context.Context, cmp domain.Compare) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Compare elasticsearch resources",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	cmd.AddCommand(diff.NewDetailCmd(ctx, cmp))
+	cmd.AddCommand(diffcluster.NewClusterCmd(ctx, cmp))
+	return cmd
+}
@@ -0,0 +1,98 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rerost/es-cli/domain"
+)
+
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// Colored returns whether output to f is colored by the mode.
+func Colored(mode string, f *os.File) bool {
+	switch mode {
+	case ColorAlways:
+		return true
+	case ColorAuto:
+		info, err := f.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0
+	default:
+		return false
+	}
+}
+
+func paint(colored bool, color string, s string) string {
+	if !colored {
+		return s
+	}
+	return color + s + colorReset
+}
+
+func changeColor(change domain.Change) string {
+	switch change.Kind {
+	case domain.ChangeAdd:
+		return colorGreen
+	case domain.ChangeRemove:
+		return colorRed
+	default:
+		return colorYellow
+	}
+}
+
+// WriteDetailDiff writes changes from a to b like diff.
+func WriteDetailDiff(w io.Writer, a, b domain.DetailSource, changes []domain.Change, colored bool) {
+	fmt.Fprintln(w, paint(colored, colorRed, "--- "+a.String()))
+	fmt.Fprintln(w, paint(colored, colorGreen, "+++ "+b.String()))
+	for _, change := range changes {
+		fmt.Fprintln(w, paint(colored, changeColor(change), change.Diff()))
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No differences")
+	}
+}
+
+// WriteClusterDiff writes drifts grouped by only in A, only in B and differing.
+func WriteClusterDiff(w io.Writer, namespaceA, namespaceB string, drifts []domain.Drift, colored bool) {
+	groups := []struct {
+		status string
+		title  string
+		color  string
+	}{
+		{domain.DriftOnlyInA, "Only in " + namespaceA, colorRed},
+		{domain.DriftOnlyInB, "Only in " + namespaceB, colorGreen},
+		{domain.DriftDiffer, "Differing", colorYellow},
+	}
+	for _, group := range groups {
+		lines := []string{}
+		for _, drift := range drifts {
+			if drift.Status != group.status {
+				continue
+			}
+			lines = append(lines, paint(colored, group.color, fmt.Sprintf("  %s %s", drift.Kind, drift.Name)))
+			for _, change := range drift.Changes {
+				lines = append(lines, paint(colored, changeColor(change), "    "+change.Diff()))
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintln(w, group.title+":")
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+	}
+	if len(drifts) == 0 {
+		fmt.Fprintln(w, "No differences")
+	}
+}
@@ -3,6 +3,7 @@ package dump
 import (
 	"context"
 	"io"
+	"io/ioutil"
 	"os"
 
 	"github.com/rerost/es-cli/domain"
@@ -11,6 +12,10 @@ import (
 )
 
 func NewIndexCmd(ctx context.Context, ind domain.Index) *cobra.Command {
+	opt := domain.DumpOpt{}
+	var queryFileName string
+	var maskFileName string
+
 	cmd := &cobra.Command{
 		Use:   "index",
 		Short: "dump index",
@@ -21,15 +26,33 @@ func NewIndexCmd(ctx context.Context, ind domain.Index) *cobra.Command {
 			case 1:
 				fp = os.Stdout
 			case 2:
-				// Read file from filename
+				// Write detail to filename, so that restore --detail creates the index
 				fileName := args[1]
-				var err error
-				fp, err = os.Open(fileName)
+				f, err := os.Create(fileName)
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				defer f.Close()
+				fp = f
+			}
+			if queryFileName != "" {
+				b, err := ioutil.ReadFile(queryFileName)
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				opt.Query = string(b)
+			}
+
+			if maskFileName != "" {
+				f, err := os.Open(maskFileName)
 				if err != nil {
 					return fail.Wrap(err)
 				}
+				defer f.Close()
+				opt.Mask = f
 			}
-			err := ind.Dump(ctx, args[0], fp)
+
+			err := ind.Dump(ctx, args[0], fp, opt)
 			if err != nil {
 				return fail.Wrap(err)
 			}
@@ -37,5 +60,19 @@ func NewIndexCmd(ctx context.Context, ind domain.Index) *cobra.Command {
 		},
 	}
 
+	cmd.Flags().IntVar(&opt.Slices, "slices", 1, "Number of parallel readers. Each slice is written to <index>_dump_<slice>.ndjson. Requires Elasticsearch 7.15 or later. OpenSearch is not supported")
+	cmd.Flags().StringVar(&opt.Dir, "output-dir", ".", "Directory to write dumped documents")
+	cmd.Flags().StringVar(&opt.KeepAlive, "keep-alive", "5m", "Keep alive of point in time or scroll. Resume is possible while point in time is alive")
+	cmd.Flags().StringVar(&opt.Checkpoint, "checkpoint", "", "Checkpoint file (default <output-dir>/<index>_dump.checkpoint.json)")
+	cmd.Flags().BoolVar(&opt.Resume, "resume", false, "Resume dump from checkpoint file. Requires Elasticsearch 7.12 or later, OpenSearch is not supported, and point in time which is kept alive for --keep-alive after the failure")
+	cmd.Flags().StringVar(&opt.Query, "query", "", `Query to filter documents. e.g. '{"range": {"created_at": {"gte": "now-7d"}}}'`)
+	cmd.Flags().StringVar(&queryFileName, "query-file", "", "File of query to filter documents")
+	cmd.Flags().StringSliceVar(&opt.Includes, "includes", nil, "Fields of _source to dump. e.g. id,user.*")
+	cmd.Flags().StringSliceVar(&opt.Excludes, "excludes", nil, "Fields of _source not to dump")
+	cmd.Flags().Int64Var(&opt.MaxDocs, "max-docs", 0, "Max number of documents to dump. 0 is unlimited")
+	cmd.Flags().StringVar(&maskFileName, "mask", "", `Masking spec file. e.g. {"salt": "secret", "fields": {"user.email": "hash", "user.name": "fake", "phone": "redact"}}`)
+	cmd.Flags().Float64Var(&opt.Sample, "sample", 0, "Ratio of documents to dump randomly. e.g. 0.1")
+	cmd.Flags().Int64Var(&opt.SampleSeed, "sample-seed", domain.DefaultSampleSeed, "Seed of --sample. The same seed dumps the same documents while the index is not changed")
+
 	return cmd
 }
@@ -0,0 +1,22 @@
+package export
+
+import (
+	"context"
+
+	export "github.com/rerost/es-cli/cmd/export/index"
+	exportstate "github.com/rerost/es-cli/cmd/export/state"
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+)
+
+func NewExportCommand(ctx context.Context, ind domain.Index, st domain.State) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export elasticsearch resources",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	cmd.AddCommand(export.NewIndexCmd(ctx, ind))
+	cmd.AddCommand(exportstate.NewStateCmd(ctx, st))
+	return cmd
+}
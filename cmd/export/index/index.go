@@ -0,0 +1,61 @@
+package export
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+	"github.com/srvc/fail"
+)
+
+func NewIndexCmd(ctx context.Context, ind domain.Index) *cobra.Command {
+	opt := domain.ExportOpt{}
+	var queryFileName string
+
+	cmd := &cobra.Command{
+		Use:   "index",
+		Short: "export documents as csv, tsv or jsonl",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(_ *cobra.Command, args []string) error {
+			var fp io.Writer
+			switch len(args) {
+			case 1:
+				fp = os.Stdout
+			case 2:
+				f, err := os.Create(args[1])
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				defer f.Close()
+				fp = f
+			}
+
+			if queryFileName != "" {
+				b, err := ioutil.ReadFile(queryFileName)
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				opt.Query = string(b)
+			}
+
+			err := ind.Export(ctx, args[0], fp, opt)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opt.Format, "format", domain.FormatCSV, "Output format. csv, tsv or jsonl")
+	cmd.Flags().StringSliceVar(&opt.Fields, "fields", nil, "Dotted field paths to write in order. e.g. _id,user.name. Default is fields of the first page, and csv and tsv fail when later documents have other fields")
+	cmd.Flags().BoolVar(&opt.NoHeader, "no-header", false, "Do not write header of csv and tsv")
+	cmd.Flags().StringVar(&opt.Array, "array", domain.ArrayJoin, "How to write arrays. join, json, first or index")
+	cmd.Flags().StringVar(&opt.ArraySeparator, "array-separator", "|", "Separator of joined arrays")
+	cmd.Flags().StringVar(&opt.Query, "query", "", "Query to filter documents")
+	cmd.Flags().StringVar(&queryFileName, "query-file", "", "File of query to filter documents")
+
+	return cmd
+}
@@ -0,0 +1,40 @@
+package export
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+	"github.com/srvc/fail"
+)
+
+func NewStateCmd(ctx context.Context, st domain.State) *cobra.Command {
+	var opt domain.ExportStateOpt
+	var writeOpt domain.WriteStateOpt
+
+	cmd := &cobra.Command{
+		Use:   "state <dir>",
+		Short: "export indices, aliases, templates, pipelines and ILM policies as state files",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			state, err := st.Export(ctx, opt)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+
+			err = domain.WriteState(args[0], state, writeOpt)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+			fmt.Printf("Exported %d indices, %d aliases, %d templates, %d pipelines and %d ILM policies\n",
+				len(state.Indices), len(state.Aliases), len(state.Templates), len(state.Pipelines), len(state.ILMPolicies))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&opt.Match, "match", nil, "Glob patterns of names to export. e.g. orders*,logs-*")
+	cmd.Flags().BoolVar(&writeOpt.Clean, "clean", false, "Remove state files in <dir> before exporting. Export fails when they exist without it")
+
+	return cmd
+}
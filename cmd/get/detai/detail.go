@@ -11,12 +11,14 @@ import (
 )
 
 func NewDetailCmd(ctx context.Context, dtl domain.Detail) *cobra.Command {
+	var opt domain.GetOpt
+
 	cmd := &cobra.Command{
 		Use:   "detail",
 		Short: "get detail",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(_ *cobra.Command, args []string) error {
-			detail, err := dtl.Get(ctx, args[0])
+			detail, err := dtl.Get(ctx, args[0], opt)
 			if err != nil {
 				return fail.Wrap(err)
 			}
@@ -27,5 +29,9 @@ func NewDetailCmd(ctx context.Context, dtl domain.Detail) *cobra.Command {
 		},
 	}
 
+	cmd.Flags().BoolVar(&opt.Portable, "portable", false, "Remove server managed settings and convert mappings for --target-version, so that create index accepts the detail")
+	cmd.Flags().StringVar(&opt.TargetVersion, "target-version", "", "Elasticsearch version of the cluster where the index is created. e.g. 6.8.0. Default is the version of the cluster")
+	cmd.Flags().BoolVar(&opt.NoAliases, "no-aliases", false, "Remove aliases")
+
 	return cmd
 }
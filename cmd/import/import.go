@@ -0,0 +1,20 @@
+package imports
+
+import (
+	"context"
+
+	imports "github.com/rerost/es-cli/cmd/import/index"
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+)
+
+func NewImportCommand(ctx context.Context, ind domain.Index) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import elasticsearch resources",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	cmd.AddCommand(imports.NewIndexCmd(ctx, ind))
+	return cmd
+}
@@ -0,0 +1,80 @@
+package imports
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+	"github.com/srvc/fail"
+)
+
+func NewIndexCmd(ctx context.Context, ind domain.Index) *cobra.Command {
+	opt := domain.ImportOpt{}
+	var detailFileName string
+	var deadLetterFileName string
+
+	cmd := &cobra.Command{
+		Use:   "index",
+		Short: "import documents from csv, tsv, json or jsonl",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(_ *cobra.Command, args []string) error {
+			var fp io.Reader
+			switch len(args) {
+			case 1:
+				fp = os.Stdin
+			case 2:
+				// Read file from filename
+				fileName := args[1]
+				f, err := os.Open(fileName)
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				defer f.Close()
+				fp = f
+
+				if opt.Format == "" {
+					opt.Format = domain.FormatOfFile(fileName)
+				}
+			}
+
+			if detailFileName != "" {
+				f, err := os.Open(detailFileName)
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				defer f.Close()
+				opt.Detail = f
+			}
+
+			if deadLetterFileName != "" {
+				f, err := os.Create(deadLetterFileName)
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				defer f.Close()
+				opt.DeadLetter = f
+			}
+
+			err := ind.Import(ctx, args[0], fp, opt)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opt.Format, "format", "", "Input format. csv, tsv, json or jsonl (default extension of the file, or json)")
+	cmd.Flags().StringVar(&opt.IDField, "id-field", "", "Field used as _id. _id is generated when empty")
+	cmd.Flags().StringToStringVar(&opt.Columns, "columns", nil, "Types of csv columns. string, int, float, bool or json. e.g. age=int,price=float")
+	cmd.Flags().StringVar(&detailFileName, "detail", "", "Create the index from the detail file before importing")
+	cmd.Flags().BoolVar(&opt.InferMapping, "infer-mapping", false, "Create the index with mapping inferred from documents")
+	cmd.Flags().IntVar(&opt.Bulk.Workers, "workers", 1, "Number of concurrent bulk requests")
+	cmd.Flags().IntVar(&opt.Bulk.BatchSize, "batch-size", domain.BATCH_SIZE, "Send a bulk request every this number of documents")
+	cmd.Flags().IntVar(&opt.Bulk.BatchBytes, "batch-bytes", 10*1024*1024, "Send a bulk request when documents exceed this size")
+	cmd.Flags().IntVar(&opt.Bulk.MaxRetry, "max-retry", 5, "Max number of retries for documents rejected with 429 Too Many Requests. 0 disables retries")
+	cmd.Flags().StringVar(&deadLetterFileName, "dead-letter", "", "Write rejected documents to this file as bulk NDJSON")
+
+	return cmd
+}
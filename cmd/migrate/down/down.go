@@ -0,0 +1,26 @@
+package migrate
+
+import (
+	"context"
+
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+	"github.com/srvc/fail"
+)
+
+func NewDownCmd(ctx context.Context, mgr domain.Migration) *cobra.Command {
+	var count int
+
+	cmd := &cobra.Command{
+		Use:   "down <migrations_dir>",
+		Short: "Revert applied migrations by down files",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return fail.Wrap(mgr.Down(ctx, args[0], count))
+		},
+	}
+
+	cmd.Flags().IntVar(&count, "count", 1, "Number of migrations to revert")
+
+	return cmd
+}
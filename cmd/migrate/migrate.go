@@ -0,0 +1,26 @@
+package migrate
+
+import (
+	"context"
+
+	down "github.com/rerost/es-cli/cmd/migrate/down"
+	status "github.com/rerost/es-cli/cmd/migrate/status"
+	up "github.com/rerost/es-cli/cmd/migrate/up"
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+)
+
+func NewMigrateCommand(ctx context.Context, mgr domain.Migration) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply migration files in a directory",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	cmd.AddCommand(
+		up.NewUpCmd(ctx, mgr),
+		down.NewDownCmd(ctx, mgr),
+		status.NewStatusCmd(ctx, mgr),
+	)
+	return cmd
+}
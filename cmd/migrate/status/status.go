@@ -0,0 +1,30 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+	"github.com/srvc/fail"
+)
+
+func NewStatusCmd(ctx context.Context, mgr domain.Migration) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status <migrations_dir>",
+		Short: "Show applied and pending migrations",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			statuses, err := mgr.Status(ctx, args[0])
+			if err != nil {
+				return fail.Wrap(err)
+			}
+			for _, status := range statuses {
+				fmt.Println(status)
+			}
+			return nil
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,22 @@
+package migrate
+
+import (
+	"context"
+
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+	"github.com/srvc/fail"
+)
+
+func NewUpCmd(ctx context.Context, mgr domain.Migration) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "up <migrations_dir>",
+		Short: "Apply pending migrations",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return fail.Wrap(mgr.Up(ctx, args[0]))
+		},
+	}
+
+	return cmd
+}
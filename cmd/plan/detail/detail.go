@@ -0,0 +1,41 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+	"github.com/srvc/fail"
+)
+
+func NewDetailCmd(ctx context.Context, dtl domain.Detail) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "detail",
+		Short: "Show changes of update detail",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(_ *cobra.Command, args []string) error {
+			var detailFp io.Reader = os.Stdin
+			if len(args) == 2 {
+				var err error
+				detailFp, err = os.Open(args[1])
+				if err != nil {
+					return fail.Wrap(err)
+				}
+			}
+
+			plans, err := dtl.Plan(ctx, args[0], detailFp)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+			for _, plan := range plans {
+				fmt.Println(plan)
+			}
+			return nil
+		},
+	}
+
+	return cmd
+}
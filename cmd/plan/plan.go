@@ -0,0 +1,24 @@
+package plan
+
+import (
+	"context"
+
+	plan "github.com/rerost/es-cli/cmd/plan/detail"
+	planstate "github.com/rerost/es-cli/cmd/plan/state"
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+)
+
+func NewPlanCommand(ctx context.Context, dtl domain.Detail, st domain.State) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Show changes without applying",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	cmd.AddCommand(
+		plan.NewDetailCmd(ctx, dtl),
+		planstate.NewStateCmd(ctx, st),
+	)
+	return cmd
+}
@@ -0,0 +1,40 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+	"github.com/srvc/fail"
+)
+
+func NewStateCmd(ctx context.Context, st domain.State) *cobra.Command {
+	var opt domain.StateOpt
+
+	cmd := &cobra.Command{
+		Use:   "state <state_file>",
+		Short: "Show operations to make the cluster the state",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			desired, err := domain.LoadState(args[0])
+			if err != nil {
+				return fail.Wrap(err)
+			}
+
+			operations, err := st.Plan(ctx, desired, opt)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+			for _, operation := range operations {
+				fmt.Println(operation)
+			}
+			fmt.Printf("Plan: %d operations\n", len(operations))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&opt.Prune, "prune", false, "Delete indices, aliases, templates, pipelines and policies which are not in the state")
+
+	return cmd
+}
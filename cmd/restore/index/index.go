@@ -11,25 +11,67 @@ import (
 )
 
 func NewIndexCmd(ctx context.Context, ind domain.Index) *cobra.Command {
+	var detailFileName string
+	var targetIndex string
+	var deadLetterFileName string
+	var maskFileName string
+	var bulkOpt domain.BulkOpt
+
 	cmd := &cobra.Command{
 		Use:   "index",
 		Short: "restore index",
-		Args:  cobra.RangeArgs(0, 1),
+		Args:  cobra.ArbitraryArgs,
 		RunE: func(_ *cobra.Command, args []string) error {
 			var fp io.Reader
 			switch len(args) {
-			case 1:
+			case 0:
 				fp = os.Stdin
-			case 2:
-				// Read file from filename
-				fileName := args[0]
-				var err error
-				fp, err = os.Open(fileName)
+			default:
+				// Read files from filenames. e.g. chunk files of sliced dump
+				readers := make([]io.Reader, len(args))
+				for n, fileName := range args {
+					f, err := os.Open(fileName)
+					if err != nil {
+						return fail.Wrap(err)
+					}
+					defer f.Close()
+					readers[n] = f
+				}
+				fp = io.MultiReader(readers...)
+			}
+
+			opt := domain.RestoreOpt{
+				TargetIndex: targetIndex,
+				Bulk:        bulkOpt,
+			}
+			if detailFileName != "" {
+				f, err := os.Open(detailFileName)
 				if err != nil {
 					return fail.Wrap(err)
 				}
+				defer f.Close()
+				opt.Detail = f
 			}
-			err := ind.Restore(ctx, fp)
+
+			if deadLetterFileName != "" {
+				f, err := os.Create(deadLetterFileName)
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				defer f.Close()
+				opt.DeadLetter = f
+			}
+
+			if maskFileName != "" {
+				f, err := os.Open(maskFileName)
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				defer f.Close()
+				opt.Mask = f
+			}
+
+			err := ind.Restore(ctx, fp, opt)
 			if err != nil {
 				return fail.Wrap(err)
 			}
@@ -37,5 +79,14 @@ func NewIndexCmd(ctx context.Context, ind domain.Index) *cobra.Command {
 		},
 	}
 
+	cmd.Flags().StringVar(&targetIndex, "target-index", "", `Restore into this index instead of the dumped one. "{index}" is replaced with the dumped index name. e.g. "{index}_restored"`)
+	cmd.Flags().IntVar(&bulkOpt.Workers, "workers", 1, "Number of concurrent bulk requests")
+	cmd.Flags().IntVar(&bulkOpt.BatchSize, "batch-size", domain.BATCH_SIZE, "Send a bulk request every this number of documents")
+	cmd.Flags().IntVar(&bulkOpt.BatchBytes, "batch-bytes", 10*1024*1024, "Send a bulk request when documents exceed this size")
+	cmd.Flags().IntVar(&bulkOpt.MaxRetry, "max-retry", 5, "Max number of retries for documents rejected with 429 Too Many Requests. 0 disables retries")
+	cmd.Flags().StringVar(&maskFileName, "mask", "", "Masking spec file. Documents are masked before restoring")
+	cmd.Flags().StringVar(&deadLetterFileName, "dead-letter", "", "Write rejected documents to this file as bulk NDJSON")
+	cmd.Flags().StringVar(&detailFileName, "detail", "", "Create the index from the dumped detail file before loading documents")
+
 	return cmd
 }
@@ -0,0 +1,22 @@
+package rollback
+
+import (
+	"context"
+
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+	"github.com/srvc/fail"
+)
+
+func NewDetailCmd(ctx context.Context, dtl domain.Detail) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "detail",
+		Short: "Point alias to indices before the last update detail",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return fail.Wrap(dtl.Rollback(ctx, args[0]))
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,20 @@
+package rollback
+
+import (
+	"context"
+
+	rollback "github.com/rerost/es-cli/cmd/rollback/detail"
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+)
+
+func NewRollbackCommand(ctx context.Context, dtl domain.Detail) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Rollback elasitcsearch resources",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	cmd.AddCommand(rollback.NewDetailCmd(ctx, dtl))
+	return cmd
+}
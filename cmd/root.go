@@ -5,15 +5,23 @@ import (
 	"os"
 
 	"github.com/rerost/es-cli/cmd/add"
+	"github.com/rerost/es-cli/cmd/apply"
 	"github.com/rerost/es-cli/cmd/copy"
 	"github.com/rerost/es-cli/cmd/count"
 	"github.com/rerost/es-cli/cmd/create"
 	"github.com/rerost/es-cli/cmd/delete"
+	"github.com/rerost/es-cli/cmd/diff"
 	"github.com/rerost/es-cli/cmd/dump"
+	"github.com/rerost/es-cli/cmd/export"
 	"github.com/rerost/es-cli/cmd/get"
+	imports "github.com/rerost/es-cli/cmd/import"
 	"github.com/rerost/es-cli/cmd/list"
+	"github.com/rerost/es-cli/cmd/migrate"
+	"github.com/rerost/es-cli/cmd/plan"
 	"github.com/rerost/es-cli/cmd/remove"
 	"github.com/rerost/es-cli/cmd/restore"
+	"github.com/rerost/es-cli/cmd/rollback"
+	"github.com/rerost/es-cli/cmd/search"
 	"github.com/rerost/es-cli/cmd/update"
 	"github.com/rerost/es-cli/domain"
 	"github.com/spf13/cobra"
@@ -24,6 +32,9 @@ func NewCmdRoot(
 	ind domain.Index,
 	dtl domain.Detail,
 	alis domain.Alias,
+	mgr domain.Migration,
+	st domain.State,
+	cmp domain.Compare,
 ) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "es-cli",
@@ -39,8 +50,16 @@ func NewCmdRoot(
 		delete.NewDeleteCommand(ctx, ind),
 		dump.NewDumpCommand(ctx, ind),
 		restore.NewRestoreCommand(ctx, ind),
+		export.NewExportCommand(ctx, ind, st),
+		imports.NewImportCommand(ctx, ind),
+		search.NewSearchCommand(ctx, ind),
 		get.NewGetCommand(ctx, dtl),
 		update.NewUpdateCommand(ctx, dtl),
+		plan.NewPlanCommand(ctx, dtl, st),
+		apply.NewApplyCommand(ctx, st),
+		rollback.NewRollbackCommand(ctx, dtl),
+		migrate.NewMigrateCommand(ctx, mgr),
+		diff.NewDiffCommand(ctx, cmp),
 		remove.NewRemoveCommand(ctx, alis),
 		NewBashCmd(),
 		NewZshCmd(),
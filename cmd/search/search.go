@@ -0,0 +1,55 @@
+package search
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+
+	"github.com/rerost/es-cli/domain"
+	"github.com/spf13/cobra"
+	"github.com/srvc/fail"
+)
+
+func NewSearchCommand(ctx context.Context, ind domain.Index) *cobra.Command {
+	opt := domain.SearchOpt{}
+	var bodyFileName string
+
+	cmd := &cobra.Command{
+		Use:   "search",
+		Short: "Search documents by query DSL or query string",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			var err error
+			switch bodyFileName {
+			case "":
+			case "-":
+				opt.Body, err = ioutil.ReadAll(os.Stdin)
+			default:
+				opt.Body, err = ioutil.ReadFile(bodyFileName)
+			}
+			if err != nil {
+				return fail.Wrap(err)
+			}
+
+			err = ind.Search(ctx, args[0], os.Stdout, opt)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&bodyFileName, "body", "", "File of search body. - reads stdin")
+	cmd.Flags().StringVarP(&opt.QueryString, "query", "q", "", "Lucene query string. e.g. 'user.name:alice AND age:>20'")
+	cmd.Flags().IntVar(&opt.Size, "size", 0, "Number of hits. Page size with --all")
+	cmd.Flags().IntVar(&opt.From, "from", 0, "Offset of hits")
+	cmd.Flags().StringSliceVar(&opt.Sort, "sort", nil, "Sort by field or field:order. e.g. created_at:desc,_score")
+	cmd.Flags().BoolVar(&opt.All, "all", false, "Read all hits by point in time, or by scroll for clusters which do not support it")
+	cmd.Flags().StringVar(&opt.Output.Format, "format", domain.FormatJSONL, "Output format. jsonl, csv or tsv")
+	cmd.Flags().StringSliceVar(&opt.Output.Fields, "fields", nil, "Dotted field paths to write in order. e.g. _id,user.name. Default is fields of the first page, and csv and tsv fail when later documents have other fields")
+	cmd.Flags().BoolVar(&opt.Output.NoHeader, "no-header", false, "Do not write header of csv and tsv")
+	cmd.Flags().StringVar(&opt.Output.Array, "array", domain.ArrayJoin, "How to write arrays. join, json, first or index")
+	cmd.Flags().StringVar(&opt.Output.ArraySeparator, "array-separator", "|", "Separator of joined arrays")
+
+	return cmd
+}
@@ -2,8 +2,11 @@ package update
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"strings"
 
 	"github.com/rerost/es-cli/domain"
 	"github.com/spf13/cobra"
@@ -11,6 +14,9 @@ import (
 )
 
 func NewDetailCmd(ctx context.Context, dtl domain.Detail) *cobra.Command {
+	var dryRun bool
+	var opt domain.UpdateOpt
+
 	cmd := &cobra.Command{
 		Use:   "detail",
 		Short: "update detail",
@@ -29,7 +35,26 @@ func NewDetailCmd(ctx context.Context, dtl domain.Detail) *cobra.Command {
 				}
 			}
 
-			err := dtl.Update(ctx, args[0], detailFp)
+			if dryRun {
+				plans, err := dtl.Plan(ctx, args[0], detailFp)
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				for _, plan := range plans {
+					fmt.Println(plan)
+				}
+				return nil
+			}
+
+			if opt.SHA == "" && (opt.NameTemplate == domain.NameSHA || strings.Contains(opt.NameTemplate, "{sha}")) {
+				sha, err := exec.Command("git", "rev-parse", "--short", "HEAD").Output()
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				opt.SHA = strings.TrimSpace(string(sha))
+			}
+
+			err := dtl.Update(ctx, args[0], detailFp, opt)
 			if err != nil {
 				return fail.Wrap(err)
 			}
@@ -38,5 +63,12 @@ func NewDetailCmd(ctx context.Context, dtl domain.Detail) *cobra.Command {
 		},
 	}
 
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print changes without updating")
+	cmd.Flags().StringVar(&opt.SafeMode, "safe-mode", "", "Keep writes while reindexing. block: block writes to old indices, catch-up: copy documents modified while reindexing by --timestamp-field")
+	cmd.Flags().StringVar(&opt.NameTemplate, "name-template", domain.NameTimestamp, "Name of new index. timestamp, version, sha or a template which has {name}, {timestamp}, {version} and {sha}. e.g. {name}-v{version}")
+	cmd.Flags().StringVar(&opt.SHA, "sha", "", "Used for {sha}. Default is the current git commit")
+	cmd.Flags().IntVar(&opt.KeepOld, "keep-old", 0, "Number of previous generations of indices kept for rollback detail")
+	cmd.Flags().StringVar(&opt.TimestampField, "timestamp-field", "", "Date field which is updated on each write. Required for --safe-mode catch-up")
+
 	return cmd
 }
@@ -16,7 +16,7 @@ import (
 )
 
 func InitializeCmd(ctx context.Context, cfg config.Config) (*cobra.Command, error) {
-	wire.Build(NewCmdRoot, es.NewBaseClient, http.NewClient, domain.NewIndex, domain.NewDetail, domain.NewAlias)
+	wire.Build(NewCmdRoot, es.NewBaseClient, http.NewClient, domain.NewIndex, domain.NewDetail, domain.NewAlias, domain.NewMigration, domain.NewState, domain.NewCompare, NewBaseClientFactory)
 	return &cobra.Command{}, nil
 }
 
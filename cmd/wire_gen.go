@@ -27,7 +27,11 @@ func InitializeCmd(ctx context.Context, cfg config.Config) (*cobra.Command, erro
 	index := domain.NewIndex(baseClient)
 	detail := domain.NewDetail(baseClient, index)
 	alias := domain.NewAlias(baseClient)
-	command := NewCmdRoot(ctx, index, detail, alias)
+	migration := domain.NewMigration(baseClient, detail)
+	state := domain.NewState(baseClient, detail)
+	baseClientFactory := NewBaseClientFactory()
+	compare := domain.NewCompare(baseClient, baseClientFactory)
+	command := NewCmdRoot(ctx, index, detail, alias, migration, state, compare)
 	return command, nil
 }
 
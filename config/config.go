@@ -3,10 +3,14 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 
 	"github.com/srvc/fail"
 )
 
+// ConfigFileName is the configuration file in the current directory
+const ConfigFileName = "escli.json"
+
 type Config struct {
 	Host               string `json:"host"`
 	Type               string `json:"type"`
@@ -63,6 +67,19 @@ func LoadConfigWithNamespace(jsonBody []byte, namespace string) (Config, error)
 	return cfg, nil
 }
 
+// LoadNamespaceConfigFile returns the config of the namespace in ConfigFileName. Fields which are not set are defaults.
+func LoadNamespaceConfigFile(namespace string) (Config, error) {
+	b, err := ioutil.ReadFile(ConfigFileName)
+	if err != nil {
+		return Config{}, fail.Wrap(err)
+	}
+	cfg, err := LoadConfigWithNamespace(b, namespace)
+	if err != nil {
+		return Config{}, fail.Wrap(err)
+	}
+	return Overwrite(DefaultConfig(), cfg), nil
+}
+
 func Overwrite(cfgOrg, cfgOverwrite Config) Config {
 	cfgDst := cfgOrg
 	if h := cfgOverwrite.Host; h != "" {
@@ -0,0 +1,283 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rerost/es-cli/infra/es"
+	"github.com/srvc/fail"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBulkWorkers    = 1
+	defaultBulkBatchBytes = 10 * 1024 * 1024
+)
+
+type BulkOpt struct {
+	// Workers is number of concurrent bulk requests
+	Workers int
+	// BatchSize flushes a batch when it has this number of documents
+	BatchSize int
+	// BatchBytes flushes a batch when it exceeds this size
+	BatchBytes int
+	// MaxRetry is max number of retries for documents rejected with 429. Documents are not retried when it is 0
+	MaxRetry int
+}
+
+type bulkPair struct {
+	meta string
+	doc  string
+}
+
+func (p bulkPair) size() int {
+	// +2 for new lines
+	return len(p.meta) + len(p.doc) + 2
+}
+
+type bulkStats struct {
+	Docs    int64
+	Bytes   int64
+	Batches int64
+	Retries int64
+	Elapsed time.Duration
+}
+
+func (s bulkStats) String() string {
+	seconds := s.Elapsed.Seconds()
+	if seconds == 0 {
+		seconds = 1
+	}
+	return strings.Join([]string{
+		fmt.Sprintf("Documents: %d", s.Docs),
+		fmt.Sprintf("Bytes: %d", s.Bytes),
+		fmt.Sprintf("Batches: %d", s.Batches),
+		fmt.Sprintf("Retries: %d", s.Retries),
+		fmt.Sprintf("Elapsed: %s", s.Elapsed),
+		fmt.Sprintf("Throughput: %.1f docs/s, %.1f KB/s", float64(s.Docs)/seconds, float64(s.Bytes)/1024/seconds),
+	}, "\n")
+}
+
+// bulkPipeline sends documents to Elasticsearch by concurrent bulk requests.
+// add blocks while all workers are busy, so that reading documents follows the speed of Elasticsearch.
+type bulkPipeline struct {
+	esBaseClient es.BaseClient
+	opt          BulkOpt
+	deadLetter   io.Writer
+
+	batch      []bulkPair
+	batchBytes int
+	batches    chan []bulkPair
+	wg         sync.WaitGroup
+	cancel     context.CancelFunc
+	start      time.Time
+
+	mu      sync.Mutex
+	err     error
+	summary es.BulkResult
+	stats   bulkStats
+}
+
+func newBulkPipeline(ctx context.Context, esBaseClient es.BaseClient, opt BulkOpt, deadLetter io.Writer) *bulkPipeline {
+	if opt.Workers <= 0 {
+		opt.Workers = defaultBulkWorkers
+	}
+	if opt.BatchSize <= 0 {
+		opt.BatchSize = BATCH_SIZE
+	}
+	if opt.BatchBytes <= 0 {
+		opt.BatchBytes = defaultBulkBatchBytes
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p := &bulkPipeline{
+		esBaseClient: esBaseClient,
+		opt:          opt,
+		deadLetter:   deadLetter,
+		batches:      make(chan []bulkPair),
+		cancel:       cancel,
+		start:        time.Now(),
+		summary:      es.BulkResult{Failed: map[string]int{}},
+	}
+
+	for n := 0; n < opt.Workers; n++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for batch := range p.batches {
+				err := p.send(ctx, batch)
+				if err != nil {
+					p.fail(err)
+				}
+			}
+		}()
+	}
+
+	return p
+}
+
+func (p *bulkPipeline) add(ctx context.Context, pair bulkPair) error {
+	p.batch = append(p.batch, pair)
+	p.batchBytes += pair.size()
+
+	if len(p.batch) >= p.opt.BatchSize || p.batchBytes >= p.opt.BatchBytes {
+		return fail.Wrap(p.flush(ctx))
+	}
+	return nil
+}
+
+func (p *bulkPipeline) flush(ctx context.Context) error {
+	if len(p.batch) == 0 {
+		return nil
+	}
+
+	select {
+	case p.batches <- p.batch:
+	case <-ctx.Done():
+		return fail.Wrap(ctx.Err())
+	}
+	p.batch = nil
+	p.batchBytes = 0
+
+	return fail.Wrap(p.error())
+}
+
+// close flushes the rest of documents and waits for all workers.
+func (p *bulkPipeline) close(ctx context.Context) (es.BulkResult, bulkStats, error) {
+	err := p.flush(ctx)
+	close(p.batches)
+	p.wg.Wait()
+	p.cancel()
+
+	p.stats.Elapsed = time.Since(p.start)
+	if err != nil {
+		return p.summary, p.stats, fail.Wrap(err)
+	}
+	return p.summary, p.stats, fail.Wrap(p.error())
+}
+
+func (p *bulkPipeline) send(ctx context.Context, batch []bulkPair) error {
+	for try := 0; ; try++ {
+		if try > 0 {
+			// Back off
+			wait := time.Second * time.Duration(try*try)
+			zap.L().Debug("Retry bulk", zap.Int("documents", len(batch)), zap.Duration("wait", wait))
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return fail.Wrap(ctx.Err())
+			}
+		}
+
+		lines := make([]string, 0, len(batch)*2)
+		for _, pair := range batch {
+			lines = append(lines, pair.meta, pair.doc)
+		}
+		body := strings.Join(lines, "\n") + "\n"
+
+		result, err := p.esBaseClient.BulkIndex(ctx, body)
+		if err != nil {
+			if es.IsTooManyRequests(err) && try < p.opt.MaxRetry {
+				p.record(es.BulkResult{}, bulkStats{Retries: 1})
+				continue
+			}
+			return fail.Wrap(err)
+		}
+
+		// Documents without an item are not known to be written
+		if len(result.Items) != len(batch) {
+			if try < p.opt.MaxRetry {
+				p.record(es.BulkResult{}, bulkStats{Retries: 1})
+				continue
+			}
+			return fail.New(fmt.Sprintf("Bulk response has %d items for %d documents", len(result.Items), len(batch)))
+		}
+
+		retry := []bulkPair{}
+		rejected := []string{}
+		for n, item := range result.Items {
+			if item.Retryable() && try < p.opt.MaxRetry {
+				retry = append(retry, batch[n])
+				continue
+			}
+			if item.Failed() {
+				rejected = append(rejected, batch[n].meta, batch[n].doc)
+			}
+		}
+		result.Retryable -= len(retry)
+
+		err = p.writeDeadLetter(rejected, result)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+
+		stats := bulkStats{Docs: int64(len(batch) - len(retry)), Bytes: int64(len(body)), Batches: 1}
+		if len(retry) > 0 {
+			stats.Retries = 1
+		}
+		p.record(result, stats)
+		zap.L().Debug("Copied", zap.Int("size", len(batch)-len(retry)))
+
+		if len(retry) == 0 {
+			return nil
+		}
+		batch = retry
+	}
+}
+
+func (p *bulkPipeline) writeDeadLetter(lines []string, result es.BulkResult) error {
+	for _, item := range result.Items {
+		if !item.Failed() || item.Retryable() {
+			continue
+		}
+		reason := ""
+		if item.Error != nil {
+			reason = item.Error.Reason
+		}
+		zap.L().Debug(
+			"Rejected document",
+			zap.String("index", item.Index),
+			zap.String("id", item.ID),
+			zap.Int("status", item.Status),
+			zap.String("reason", reason),
+		)
+	}
+
+	if p.deadLetter == nil || len(lines) == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := io.WriteString(p.deadLetter, strings.Join(lines, "\n")+"\n")
+	return fail.Wrap(err)
+}
+
+func (p *bulkPipeline) record(result es.BulkResult, stats bulkStats) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.summary.Merge(result)
+	p.stats.Docs += stats.Docs
+	p.stats.Bytes += stats.Bytes
+	p.stats.Batches += stats.Batches
+	p.stats.Retries += stats.Retries
+}
+
+func (p *bulkPipeline) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+		p.cancel()
+	}
+}
+
+func (p *bulkPipeline) error() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
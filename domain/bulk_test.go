@@ -0,0 +1,240 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rerost/es-cli/config"
+	"github.com/rerost/es-cli/infra/es"
+)
+
+// statusShortItems responds 200 with items fewer than documents.
+const statusShortItems = -1
+
+// bulkServer accepts bulk requests. It records number of documents of each request and max number of concurrent requests.
+type bulkServer struct {
+	mu       sync.Mutex
+	status   []int
+	delay    time.Duration
+	sizes    []int
+	inFlight int
+	maxLoad  int
+}
+
+func newBulkServer(t *testing.T, s *bulkServer) es.BaseClient {
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	baseClient, _ := es.NewBaseClient(config.Config{Host: ts.URL, Type: "_doc"}, ts.Client())
+	return baseClient
+}
+
+func (s *bulkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		fmt.Fprint(w, `{"version": {"number": "7.17.0"}}`)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	docs := strings.Count(string(body), "\n") / 2
+
+	s.mu.Lock()
+	s.sizes = append(s.sizes, docs)
+	status := http.StatusOK
+	if len(s.status) > 0 {
+		status = s.status[0]
+		s.status = s.status[1:]
+	}
+	s.inFlight++
+	if s.inFlight > s.maxLoad {
+		s.maxLoad = s.inFlight
+	}
+	s.mu.Unlock()
+
+	time.Sleep(s.delay)
+
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+
+	if status == statusShortItems {
+		status = http.StatusOK
+		docs--
+	}
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		fmt.Fprint(w, `{"error": "rejected"}`)
+		return
+	}
+	items := make([]string, docs)
+	for n := range items {
+		items[n] = `{"index": {"_index": "orders", "status": 201}}`
+	}
+	fmt.Fprintf(w, `{"items": [%s]}`, strings.Join(items, ","))
+}
+
+func addDocs(ctx context.Context, t *testing.T, p *bulkPipeline, count int) {
+	for n := 0; n < count; n++ {
+		// 21 bytes with new lines
+		if err := p.add(ctx, bulkPair{meta: `{"index":{}}`, doc: fmt.Sprintf(`{"n":%d}`, n%10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBulkPipelineFlush(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name  string
+		opt   BulkOpt
+		docs  int
+		sizes []int
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name:  "Batch size and the last partial batch",
+			opt:   BulkOpt{BatchSize: 2},
+			docs:  5,
+			sizes: []int{2, 2, 1},
+		},
+		{
+			name:  "Batch bytes",
+			opt:   BulkOpt{BatchSize: 100, BatchBytes: 50},
+			docs:  5,
+			sizes: []int{3, 2},
+		},
+		{
+			name:  "Batch size wins over batch bytes",
+			opt:   BulkOpt{BatchSize: 2, BatchBytes: 50},
+			docs:  4,
+			sizes: []int{2, 2},
+		},
+		{
+			name:  "Nothing is sent without documents",
+			opt:   BulkOpt{BatchSize: 2},
+			docs:  0,
+			sizes: nil,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			server := &bulkServer{}
+			p := newBulkPipeline(ctx, newBulkServer(t, server), inOut.opt, nil)
+
+			addDocs(ctx, t, p, inOut.docs)
+			result, stats, err := p.close(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(inOut.sizes, server.sizes); diff != "" {
+				t.Errorf("Not match batches, diff(-want, +got) %s", diff)
+			}
+			if result.Succeeded != inOut.docs || stats.Docs != int64(inOut.docs) || stats.Batches != int64(len(inOut.sizes)) {
+				t.Errorf("Not match stats, got %+v %+v", result, stats)
+			}
+		})
+	}
+}
+
+func TestBulkPipelineWorkers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	server := &bulkServer{delay: 100 * time.Millisecond}
+	p := newBulkPipeline(ctx, newBulkServer(t, server), BulkOpt{Workers: 3, BatchSize: 1}, nil)
+
+	addDocs(ctx, t, p, 9)
+	_, stats, err := p.close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.maxLoad != 3 {
+		t.Errorf("Requests must be sent by 3 workers at most, got %d", server.maxLoad)
+	}
+	if stats.Docs != 9 || stats.Batches != 9 {
+		t.Errorf("Not match stats, got %+v", stats)
+	}
+}
+
+func TestBulkPipelineRetry(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name     string
+		maxRetry int
+		status   []int
+		sizes    []int
+		retries  int64
+		hasError bool
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name:     "429 is retried",
+			maxRetry: 1,
+			status:   []int{http.StatusTooManyRequests, http.StatusOK},
+			sizes:    []int{2, 2},
+			retries:  1,
+		},
+		{
+			name:     "Retries are disabled by 0",
+			maxRetry: 0,
+			status:   []int{http.StatusTooManyRequests},
+			sizes:    []int{2},
+			hasError: true,
+		},
+		{
+			name:     "Missing items are retried",
+			maxRetry: 1,
+			status:   []int{statusShortItems, http.StatusOK},
+			sizes:    []int{2, 2},
+			retries:  1,
+		},
+		{
+			name:     "Missing items fail without retries",
+			maxRetry: 0,
+			status:   []int{statusShortItems},
+			sizes:    []int{2},
+			hasError: true,
+		},
+		{
+			name:     "Other errors are not retried",
+			maxRetry: 1,
+			status:   []int{http.StatusBadRequest},
+			sizes:    []int{2},
+			hasError: true,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			server := &bulkServer{status: inOut.status}
+			p := newBulkPipeline(ctx, newBulkServer(t, server), BulkOpt{BatchSize: 2, MaxRetry: inOut.maxRetry}, nil)
+
+			addDocs(ctx, t, p, 2)
+			_, stats, err := p.close(ctx)
+			if (err != nil) != inOut.hasError {
+				t.Fatalf("hasError want %v, got %v", inOut.hasError, err)
+			}
+			if diff := cmp.Diff(inOut.sizes, server.sizes); diff != "" {
+				t.Errorf("Not match requests, diff(-want, +got) %s", diff)
+			}
+			if stats.Retries != inOut.retries {
+				t.Errorf("Retries want %d, got %d", inOut.retries, stats.Retries)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/srvc/fail"
+)
+
+// dumpCheckpoint is the cursor of dump. It is used to resume dump.
+type dumpCheckpoint struct {
+	Index string `json:"index"`
+	// PitID is the point in time shared by slices. It is updated by the latest id of slices
+	PitID   string            `json:"pit_id"`
+	Options dumpOptions       `json:"options"`
+	Slices  []sliceCheckpoint `json:"slices"`
+}
+
+// dumpOptions are options which decide documents to dump. Resume is rejected when they are changed, so that documents of different options are not mixed.
+type dumpOptions struct {
+	Query      interface{} `json:"query"`
+	Includes   []string    `json:"includes"`
+	Excludes   []string    `json:"excludes"`
+	Sample     float64     `json:"sample"`
+	SampleSeed int64       `json:"sample_seed"`
+	MaxDocs    int64       `json:"max_docs"`
+}
+
+func (o dumpOptions) equal(other dumpOptions) bool {
+	return jsonString(o) == jsonString(other)
+}
+
+type sliceCheckpoint struct {
+	PitID       string        `json:"pit_id"`
+	SearchAfter []interface{} `json:"search_after"`
+	// Docs is number of documents written
+	Docs int64 `json:"docs"`
+	// Offset is size of the chunk file. Documents after offset are written after the checkpoint, so they are truncated on resume.
+	Offset int64 `json:"offset"`
+	Done   bool  `json:"done"`
+}
+
+func checkpointFileName(dir string, indexName string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s_dump.checkpoint.json", indexName))
+}
+
+func loadCheckpoint(fileName string) (dumpCheckpoint, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return dumpCheckpoint{}, fail.Wrap(err)
+	}
+
+	checkpoint := dumpCheckpoint{}
+	err = json.Unmarshal(b, &checkpoint)
+	return checkpoint, fail.Wrap(err)
+}
+
+// checkpointer persists the checkpoint. All methods do nothing when checkpointer is nil.
+type checkpointer struct {
+	mu         sync.Mutex
+	fileName   string
+	checkpoint dumpCheckpoint
+}
+
+func (c *checkpointer) save(slice int, state sliceCheckpoint) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checkpoint.Slices[slice] = state
+	if state.PitID != "" {
+		c.checkpoint.PitID = state.PitID
+	}
+	b, err := json.Marshal(c.checkpoint)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	// Write to temporary file, then rename it so that checkpoint is not broken when killed while writing
+	tmpFileName := c.fileName + ".tmp"
+	err = ioutil.WriteFile(tmpFileName, b, 0644)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	return fail.Wrap(os.Rename(tmpFileName, c.fileName))
+}
+
+// pitID returns the latest point in time id.
+func (c *checkpointer) pitID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checkpoint.PitID
+}
+
+// remove removes the checkpoint file. It is called when dump is completed.
+func (c *checkpointer) remove() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := os.Remove(c.fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return fail.Wrap(err)
+}
@@ -0,0 +1,316 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/rerost/es-cli/infra/es"
+	"github.com/srvc/fail"
+)
+
+const (
+	// CodeDrift is the code of errors which mean differences are found
+	CodeDrift = "Drift"
+
+	DriftOnlyInA = "only-in-a"
+	DriftOnlyInB = "only-in-b"
+	DriftDiffer  = "differ"
+)
+
+// DetailSource is an index or an alias of a cluster, or a detail file.
+type DetailSource struct {
+	// Namespace is a namespace of the configuration file. Empty is the cluster of options
+	Namespace string
+	Index     string
+	File      string
+}
+
+func (s DetailSource) String() string {
+	switch {
+	case s.File != "":
+		return s.File
+	case s.Namespace != "":
+		return s.Namespace + ":" + s.Index
+	default:
+		return s.Index
+	}
+}
+
+type CompareOpt struct {
+	// Match is glob patterns of names which are compared by Cluster. All names are compared when it is empty
+	Match         []string
+	IgnoreAliases bool
+	// Ignore is paths or glob patterns of paths which are not compared. e.g. settings.index.number_of_replicas
+	Ignore []string
+	// NameTemplate is the name template of update detail. Indices named by it are compared as the same pattern
+	NameTemplate string
+}
+
+// Compare compares resources between indices or clusters. Values are normalized, so that only meaningful differences are returned.
+type Compare interface {
+	Detail(ctx context.Context, a, b DetailSource, opt CompareOpt) ([]Change, error)
+	// Cluster compares index patterns, aliases, templates, pipelines and ILM policies between namespaces
+	Cluster(ctx context.Context, namespaceA, namespaceB string, opt CompareOpt) ([]Drift, error)
+}
+
+// Drift is a resource which exists in only one of clusters or differs.
+type Drift struct {
+	Kind    string
+	Name    string
+	Status  string
+	Changes []Change
+}
+
+func NewCompare(esBaseClient es.BaseClient, clientFactory es.BaseClientFactory) Compare {
+	return compareImpl{
+		esBaseClient:  esBaseClient,
+		clientFactory: clientFactory,
+	}
+}
+
+type compareImpl struct {
+	esBaseClient  es.BaseClient
+	clientFactory es.BaseClientFactory
+}
+
+func (c compareImpl) client(namespace string) (es.BaseClient, error) {
+	if namespace == "" {
+		return c.esBaseClient, nil
+	}
+	client, err := c.clientFactory(namespace)
+	return client, fail.Wrap(err)
+}
+
+func (c compareImpl) Detail(ctx context.Context, a, b DetailSource, opt CompareOpt) ([]Change, error) {
+	detailA, err := c.detail(ctx, a)
+	if err != nil {
+		return nil, fail.Wrap(err, fail.WithParam("source", a.String()))
+	}
+	detailB, err := c.detail(ctx, b)
+	if err != nil {
+		return nil, fail.Wrap(err, fail.WithParam("source", b.String()))
+	}
+
+	// Aliases of a detail file are not compared when it does not have aliases
+	if (a.File != "" && detailA.Alias == nil) || (b.File != "" && detailB.Alias == nil) {
+		opt.IgnoreAliases = true
+	}
+	changes, err := diffDetail(detailA, detailB, opt)
+	return changes, fail.Wrap(err)
+}
+
+// detail returns the detail of the source. An alias must point to one index.
+func (c compareImpl) detail(ctx context.Context, source DetailSource) (es.IndexDetail, error) {
+	if source.File != "" {
+		b, err := ioutil.ReadFile(source.File)
+		if err != nil {
+			return es.IndexDetail{}, fail.Wrap(err)
+		}
+		detail := es.IndexDetail{}
+		err = json.Unmarshal(b, &detail)
+		return detail, fail.Wrap(err)
+	}
+
+	client, err := c.client(source.Namespace)
+	if err != nil {
+		return es.IndexDetail{}, fail.Wrap(err)
+	}
+	indices, err := client.ListAlias(ctx, source.Index)
+	if err != nil {
+		return es.IndexDetail{}, fail.Wrap(err)
+	}
+	if len(indices) != 1 {
+		return es.IndexDetail{}, fail.New(fmt.Sprintf("%s points to %d indices", source.Index, len(indices)))
+	}
+	detail, err := client.DetailIndex(ctx, indices[0].Name)
+	return detail, fail.Wrap(err)
+}
+
+// diffDetail returns differences of normalized settings, mappings and aliases from a to b.
+func diffDetail(a, b es.IndexDetail, opt CompareOpt) ([]Change, error) {
+	normalizedA, err := normalizeDetail(a)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	normalizedB, err := normalizeDetail(b)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	all := []Change{}
+	all = append(all, diffValue("settings", normalizedA.Settings, normalizedB.Settings)...)
+	all = append(all, diffValue("mappings", normalizedA.Mappings, normalizedB.Mappings)...)
+	if !opt.IgnoreAliases {
+		all = append(all, diffValue("aliases", normalizedA.Aliases, normalizedB.Aliases)...)
+	}
+
+	changes := []Change{}
+	for _, change := range all {
+		if !ignoredPath(opt.Ignore, change.Path) {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// ignoredPath returns whether the path or its parent matches any of patterns.
+func ignoredPath(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if path == pattern || strings.HasPrefix(path, pattern+".") {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Cluster compares the latest index of each index pattern, because indices which are created by update detail have suffixes. e.g. orders_20261018_150405
+// Aliases are compared by index patterns which they point to.
+func (c compareImpl) Cluster(ctx context.Context, namespaceA, namespaceB string, opt CompareOpt) ([]Drift, error) {
+	stateA, err := c.snapshot(ctx, namespaceA, opt)
+	if err != nil {
+		return nil, fail.Wrap(err, fail.WithParam("namespace", namespaceA))
+	}
+	stateB, err := c.snapshot(ctx, namespaceB, opt)
+	if err != nil {
+		return nil, fail.Wrap(err, fail.WithParam("namespace", namespaceB))
+	}
+
+	diffIndex := func(a, b interface{}) ([]Change, error) {
+		detailA, err := toIndexDetail(a)
+		if err != nil {
+			return nil, fail.Wrap(err)
+		}
+		detailB, err := toIndexDetail(b)
+		if err != nil {
+			return nil, fail.Wrap(err)
+		}
+		changes, err := diffDetail(detailA, detailB, opt)
+		return changes, fail.Wrap(err)
+	}
+	diffResource := func(a, b interface{}) ([]Change, error) {
+		return compareResource(a, b, opt), nil
+	}
+
+	drifts := []Drift{}
+	groups := []struct {
+		kind string
+		a, b map[string]interface{}
+		diff func(a, b interface{}) ([]Change, error)
+	}{
+		{KindIndex, indexPatterns(stateA.Indices, opt.NameTemplate), indexPatterns(stateB.Indices, opt.NameTemplate), diffIndex},
+		{KindAlias, aliasPatterns(stateA, opt.NameTemplate), aliasPatterns(stateB, opt.NameTemplate), diffResource},
+		{KindTemplate, stateA.Templates, stateB.Templates, diffResource},
+		{KindPipeline, stateA.Pipelines, stateB.Pipelines, diffResource},
+		{KindILMPolicy, stateA.ILMPolicies, stateB.ILMPolicies, diffResource},
+	}
+	for _, group := range groups {
+		if group.kind == KindAlias && opt.IgnoreAliases {
+			continue
+		}
+		for _, name := range sortedKeys(merge(group.a, group.b)) {
+			a, inA := group.a[name]
+			b, inB := group.b[name]
+			switch {
+			case !inB:
+				drifts = append(drifts, Drift{Kind: group.kind, Name: name, Status: DriftOnlyInA})
+			case !inA:
+				drifts = append(drifts, Drift{Kind: group.kind, Name: name, Status: DriftOnlyInB})
+			default:
+				changes, err := group.diff(a, b)
+				if err != nil {
+					return nil, fail.Wrap(err, fail.WithParam(group.kind, name))
+				}
+				if len(changes) > 0 {
+					drifts = append(drifts, Drift{Kind: group.kind, Name: name, Status: DriftDiffer, Changes: changes})
+				}
+			}
+		}
+	}
+	return drifts, nil
+}
+
+func (c compareImpl) snapshot(ctx context.Context, namespace string, opt CompareOpt) (DesiredState, error) {
+	client, err := c.client(namespace)
+	if err != nil {
+		return DesiredState{}, fail.Wrap(err)
+	}
+	state, err := stateImpl{esBaseClient: client}.Export(ctx, ExportStateOpt{Match: opt.Match})
+	return state, fail.Wrap(err)
+}
+
+// indexPatterns returns the latest detail of each index pattern.
+func indexPatterns(indices map[string]interface{}, template string) map[string]interface{} {
+	latest := map[string]string{}
+	for _, name := range sortedKeys(indices) {
+		parsed := parseIndexNameByTemplate(template, name)
+		if current, ok := latest[parsed.base]; !ok || parsed.version >= parseIndexNameByTemplate(template, current).version {
+			latest[parsed.base] = name
+		}
+	}
+	result := make(map[string]interface{}, len(latest))
+	for pattern, name := range latest {
+		result[pattern] = indices[name]
+	}
+	return result
+}
+
+// aliasPatterns returns index patterns which each alias points to.
+func aliasPatterns(state DesiredState, template string) map[string]interface{} {
+	result := map[string]interface{}{}
+	for alias, indices := range state.Aliases {
+		patterns := map[string]bool{}
+		for _, index := range indices {
+			patterns[parseIndexNameByTemplate(template, index).base] = true
+		}
+		values := []interface{}{}
+		for _, pattern := range sortedKeys(patterns) {
+			values = append(values, pattern)
+		}
+		result[alias] = values
+	}
+	return result
+}
+
+func toIndexDetail(value interface{}) (es.IndexDetail, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return es.IndexDetail{}, fail.Wrap(err)
+	}
+	detail := es.IndexDetail{}
+	err = json.Unmarshal(b, &detail)
+	return detail, fail.Wrap(err)
+}
+
+// compareResource returns differences of templates, pipelines or policies. Default values which exist in only one side are ignored.
+func compareResource(a, b interface{}, opt CompareOpt) []Change {
+	changes := []Change{}
+	for _, change := range diffValue("", normalizeResource(a), normalizeResource(b)) {
+		change.Path = strings.TrimPrefix(change.Path, ".")
+		switch {
+		case change.Kind == ChangeAdd && isDefaultValue(change.New):
+		case change.Kind == ChangeRemove && isDefaultValue(change.Old):
+		case ignoredPath(opt.Ignore, change.Path):
+		default:
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+func merge(a, b map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(a)+len(b))
+	for key, value := range a {
+		result[key] = value
+	}
+	for key, value := range b {
+		result[key] = value
+	}
+	return result
+}
@@ -0,0 +1,121 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rerost/es-cli/infra/es"
+)
+
+func TestDiffDetail(t *testing.T) {
+	t.Parallel()
+
+	a := es.IndexDetail{
+		Setting: map[string]interface{}{"index": map[string]interface{}{"number_of_shards": "1", "number_of_replicas": "1", "uuid": "a"}},
+		Mapping: map[string]interface{}{"_doc": map[string]interface{}{"properties": map[string]interface{}{"n": map[string]interface{}{"type": "long"}}}},
+		Alias:   map[string]interface{}{"orders": map[string]interface{}{}},
+	}
+	b := es.IndexDetail{
+		Setting: map[string]interface{}{"number_of_shards": 1, "number_of_replicas": 2},
+		Mapping: map[string]interface{}{"properties": map[string]interface{}{"n": map[string]interface{}{"type": "keyword"}, "tag": map[string]interface{}{"type": "keyword"}}},
+	}
+
+	inOuts := []struct {
+		name string
+		opt  CompareOpt
+		want []Change
+	}{
+		{
+			name: "All",
+			want: []Change{
+				{Path: "settings.index.number_of_replicas", Kind: ChangeModify, Old: "1", New: "2"},
+				{Path: "mappings.properties.n.type", Kind: ChangeModify, Old: "long", New: "keyword"},
+				{Path: "mappings.properties.tag", Kind: ChangeAdd, New: map[string]interface{}{"type": "keyword"}},
+				{Path: "aliases.orders", Kind: ChangeRemove, Old: map[string]interface{}{}},
+			},
+		},
+		{
+			name: "Ignore",
+			opt:  CompareOpt{IgnoreAliases: true, Ignore: []string{"settings.index.number_of_replicas", "mappings.properties.t*"}},
+			want: []Change{
+				{Path: "mappings.properties.n.type", Kind: ChangeModify, Old: "long", New: "keyword"},
+			},
+		},
+	}
+
+	for _, inOut := range inOuts {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := diffDetail(a, b, inOut.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(inOut.want, got); diff != "" {
+				t.Errorf("Not match changes, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestIndexPatterns(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name     string
+		template string
+		indices  map[string]interface{}
+		want     map[string]interface{}
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name: "Suffixes",
+			indices: map[string]interface{}{
+				"orders_v2":            "v2",
+				"orders_v10":           "v10",
+				"logs_20261001_000000": "old",
+				"logs_20261018_150405": "new",
+				"users":                "users",
+			},
+			want: map[string]interface{}{
+				"orders": "v10",
+				"logs":   "new",
+				"users":  "users",
+			},
+		},
+		{
+			name:     "SHA",
+			template: NameSHA,
+			indices: map[string]interface{}{
+				"orders_1a2b3c4": "sha",
+				"logs_v2":        "v2",
+			},
+			want: map[string]interface{}{
+				"orders": "sha",
+				"logs":   "v2",
+			},
+		},
+		{
+			name:     "Custom template",
+			template: "{name}-{sha}-v{version}",
+			indices: map[string]interface{}{
+				"orders-1a2b3c4-v2": "v2",
+				"orders-5d6e7f8-v3": "v3",
+			},
+			want: map[string]interface{}{
+				"orders": "v3",
+			},
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			got := indexPatterns(inOut.indices, inOut.template)
+			if diff := cmp.Diff(inOut.want, got); diff != "" {
+				t.Errorf("Not match patterns, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package domain
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rerost/es-cli/config"
+)
+
+func TestCompatibility(t *testing.T) {
+	t.Parallel()
+
+	dump := strings.Join([]string{
+		`{"index":{"_index":"orders","_id":"1"}}`, `{"n":1}`,
+		`{"index":{"_index":"orders","_type":"_doc","_id":"2"}}`, `{"n":2}`,
+	}, "\n")
+
+	type InOutPairs struct {
+		name     string
+		root     string
+		pit      bool
+		restored []string
+		imported string
+		mapping  string
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name:     "Elasticsearch 6.x",
+			root:     `{"version": {"number": "6.8.0"}}`,
+			pit:      false,
+			restored: []string{`{"index":{"_id":"1","_index":"orders","_type":"doc"}}`, `{"index":{"_id":"2","_index":"orders","_type":"_doc"}}`},
+			imported: `{"index":{"_index":"orders","_type":"doc"}}`,
+			mapping:  `{"mappings":{"doc":{`,
+		},
+		{
+			name:     "Elasticsearch 7.10",
+			root:     `{"version": {"number": "7.10.0"}}`,
+			pit:      false,
+			restored: []string{`{"index":{"_id":"1","_index":"orders"}}`, `{"index":{"_id":"2","_index":"orders"}}`},
+			imported: `{"index":{"_index":"orders"}}`,
+			mapping:  `{"mappings":{"properties":{`,
+		},
+		{
+			name:     "Elasticsearch 7.17",
+			root:     `{"version": {"number": "7.17.0"}}`,
+			pit:      true,
+			restored: []string{`{"index":{"_id":"1","_index":"orders"}}`, `{"index":{"_id":"2","_index":"orders"}}`},
+			imported: `{"index":{"_index":"orders"}}`,
+			mapping:  `{"mappings":{"properties":{`,
+		},
+		{
+			name:     "Elasticsearch 8.x",
+			root:     `{"version": {"number": "8.11.0"}}`,
+			pit:      true,
+			restored: []string{`{"index":{"_id":"1","_index":"orders"}}`, `{"index":{"_id":"2","_index":"orders"}}`},
+			imported: `{"index":{"_index":"orders"}}`,
+			mapping:  `{"mappings":{"properties":{`,
+		},
+		{
+			name:     "OpenSearch",
+			root:     `{"version": {"number": "2.11.0", "distribution": "opensearch"}}`,
+			pit:      false,
+			restored: []string{`{"index":{"_id":"1","_index":"orders"}}`, `{"index":{"_id":"2","_index":"orders"}}`},
+			imported: `{"index":{"_index":"orders"}}`,
+			mapping:  `{"mappings":{"properties":{`,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			server, baseClient := newFakeServerWithConfig(t, config.Config{Type: "doc"}, inOut.root, map[string][]fakeResponse{
+				"POST /orders/_pit": respond(`{"id": "p1"}`),
+				// Restore writes 2 documents, and import writes 1 document
+				"POST /_bulk": respond(`{"items": [{"index": {"status": 201}}, {"index": {"status": 201}}]}`, `{"items": [{"index": {"status": 201}}]}`),
+				"PUT /orders": respond(`{"acknowledged": true}`),
+			})
+			indexDomain := indexImpl{esBaseClient: baseClient}
+
+			readers, _, err := openReaders(ctx, baseClient, "orders", readerOpt{}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := readers[0].(*pitReader); ok != inOut.pit {
+				t.Errorf("Point in time want %v, got %T", inOut.pit, readers[0])
+			}
+
+			err = indexDomain.Restore(ctx, strings.NewReader(dump), RestoreOpt{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = indexDomain.Import(ctx, "orders", strings.NewReader(`{"n":3}`), ImportOpt{Format: FormatJSONL, InferMapping: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Inferred mapping is typed by the configured type for 6.x
+			if created := server.bodies("PUT /orders"); len(created) != 1 || !strings.HasPrefix(created[0], inOut.mapping) {
+				t.Errorf("Not match mapping, want %s..., got %v", inOut.mapping, created)
+			}
+
+			bulks := server.bodies("POST /_bulk")
+			if len(bulks) != 2 {
+				t.Fatalf("Bulk must be requested by restore and import, got %v", bulks)
+			}
+			restored := []string{}
+			for n, line := range strings.Split(strings.TrimSpace(bulks[0]), "\n") {
+				if n%2 == 0 {
+					restored = append(restored, line)
+				}
+			}
+			if diff := cmp.Diff(inOut.restored, restored); diff != "" {
+				t.Errorf("Not match restored metadata, diff(-want, +got) %s", diff)
+			}
+			if imported := strings.Split(bulks[1], "\n")[0]; imported != inOut.imported {
+				t.Errorf("Not match imported metadata, want %s, got %s", inOut.imported, imported)
+			}
+		})
+	}
+}
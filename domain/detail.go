@@ -2,17 +2,28 @@ package domain
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/rerost/es-cli/infra/es"
 	"github.com/srvc/fail"
+	"go.uber.org/zap"
 )
 
 type Detail interface {
-	Get(ctx context.Context, index string) (string, error)
-	Update(ctx context.Context, aliasName string, detail io.Reader) error
+	Get(ctx context.Context, index string, opt GetOpt) (string, error)
+	// Update updates detail of an alias or an index by reindex
+	Update(ctx context.Context, name string, detail io.Reader, opt UpdateOpt) error
+	// Rollback points the alias to the indices before the last update
+	Rollback(ctx context.Context, aliasName string) error
+	// Plan returns changes of each index which Update applies
+	Plan(ctx context.Context, name string, detail io.Reader) ([]IndexPlan, error)
 }
 
 func NewDetail(esBaseClient es.BaseClient, indexDomain Index) Detail {
@@ -27,49 +38,537 @@ type detailImpl struct {
 	indexDomain  Index
 }
 
-func (d detailImpl) Get(ctx context.Context, index string) (string, error) {
+type GetOpt struct {
+	// Portable removes server managed settings and converts mappings for TargetVersion, so that create index accepts the detail on other clusters
+	Portable bool
+	// TargetVersion decides whether mappings are wrapped by the type. Default is the version of the cluster
+	TargetVersion string
+	NoAliases     bool
+}
+
+func (d detailImpl) Get(ctx context.Context, index string, opt GetOpt) (string, error) {
 	detail, err := d.esBaseClient.DetailIndex(ctx, index)
+	if err != nil {
+		return "", fail.Wrap(err)
+	}
+	if opt.NoAliases {
+		detail.Alias = map[string]interface{}{}
+	}
+	if !opt.Portable {
+		return detail.String(), nil
+	}
+
+	target := es.ServerInfo{Version: es.Version{Number: opt.TargetVersion}, Distribution: es.DistributionElasticsearch}
+	if opt.TargetVersion == "" {
+		target, err = d.esBaseClient.ServerInfo(ctx)
+		if err != nil {
+			return "", fail.Wrap(err)
+		}
+	}
+	detail, err = portableDetail(detail, target)
 	return detail.String(), fail.Wrap(err)
 }
 
-func (d detailImpl) Update(ctx context.Context, aliasName string, fp io.Reader) error {
-	// Thinking only alias case
-	// Rethink when index
-	// TODO think index case
+// defaultTypeName is used to wrap typeless mappings for 6.x
+const defaultTypeName = "_doc"
+
+// portableDetail returns the detail without server managed settings. Mappings are typed for 6.x, otherwise typeless.
+func portableDetail(detail es.IndexDetail, target es.ServerInfo) (es.IndexDetail, error) {
+	detail, err := creatableDetail(detail)
+	if err != nil {
+		return es.IndexDetail{}, fail.Wrap(err)
+	}
+
+	mappings, ok := detail.Mapping.(map[string]interface{})
+	if !ok || len(mappings) == 0 {
+		return detail, nil
+	}
+	typeName, typed := es.MappingType(mappings)
+	if !typed {
+		typeName = defaultTypeName
+	}
+	typeless := typelessMapping(mappings)
 
-	var detailJSON string
+	if target.Typed() {
+		detail.Mapping = map[string]interface{}{typeName: typeless}
+	} else {
+		detail.Mapping = typeless
+	}
+	return detail, nil
+}
 
+// Update applies the detail by _mapping, _settings and _aliases when it is possible.
+// Otherwise it creates new indices with the detail, copies documents and switches aliases.
+// When name is an index, the index is replaced with an alias which has the same name, so that clients keep working.
+func (d detailImpl) Update(ctx context.Context, name string, fp io.Reader, opt UpdateOpt) error {
+	err := opt.validate()
+	if err != nil {
+		return fail.Wrap(err)
+	}
 	body, err := ioutil.ReadAll(fp)
-	detailJSON = string(body)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	detailJSON := string(body)
+	desired := es.IndexDetail{}
+	err = json.Unmarshal(body, &desired)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	indices, err := d.esBaseClient.ListAlias(ctx, name)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	plans, err := d.plan(ctx, name, indices, desired)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	reindex := false
+	for _, plan := range plans {
+		reindex = reindex || plan.Reindex()
+	}
+	if !reindex {
+		for _, plan := range plans {
+			err = d.applyInPlace(ctx, plan, desired, name)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+		}
+		return nil
+	}
+
+	if len(indices) > 0 {
+		// New indices keep settings, mappings and aliases which the detail omits
+		current, err := d.esBaseClient.DetailIndex(ctx, indices[0].Name)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		detailJSON, err = mergeDetail(current, desired, name)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+	}
+
+	if len(indices) == 1 && indices[0].Name == name {
+		return fail.Wrap(d.updateIndex(ctx, name, detailJSON, opt))
+	}
+	return fail.Wrap(d.updateAlias(ctx, name, indices, detailJSON, opt))
+}
+
+func (d detailImpl) updateIndex(ctx context.Context, indexName string, detailJSON string, opt UpdateOpt) (err error) {
+	namer, err := d.newIndexNamer(ctx, opt)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	newIndexName, err := namer.name("", indexName)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	m := d.newMigrator(opt, []indexMigration{{oldIndex: indexName, newIndex: newIndexName}})
+	defer m.rollbackOnError(ctx, &err)
 
-	indices, err := d.esBaseClient.ListAlias(ctx, aliasName)
+	err = m.copy(ctx, detailJSON)
 	if err != nil {
 		return fail.Wrap(err)
 	}
 
-	if len(indices) != 1 {
-		return fail.New("Support only 1-alias 1-index case")
+	if opt.KeepOld > 0 {
+		zap.L().Warn("Old index is not kept, because alias can not have the same name as an index", zap.String("index", indexName))
+	}
+	// Alias can not have the same name as an index, so the index is deleted and the alias is added at once
+	err = d.esBaseClient.UpdateAliases(ctx, []es.AliasAction{
+		{Type: es.AliasActionAdd, Index: newIndexName, Alias: indexName},
+		{Type: es.AliasActionRemoveIndex, Index: indexName},
+	})
+	if err != nil {
+		return fail.Wrap(err)
 	}
+	m.commit()
+	return nil
+}
 
-	oldIndexName := indices[0].Name
+func (d detailImpl) updateAlias(ctx context.Context, aliasName string, oldIndices es.Indices, detailJSON string, opt UpdateOpt) (err error) {
+	if len(oldIndices) == 0 {
+		return fail.New(fmt.Sprintf("Not found index of alias %s", aliasName))
+	}
 
-	newIndexName := aliasName + time.Now().Format("_20060102_150405")
-	err = d.esBaseClient.CreateIndex(ctx, newIndexName, detailJSON)
+	namer, err := d.newIndexNamer(ctx, opt)
 	if err != nil {
 		return fail.Wrap(err)
 	}
+	migrations := make([]indexMigration, len(oldIndices))
+	actions := []es.AliasAction{}
+	for n, oldIndex := range oldIndices {
+		base := ""
+		if len(oldIndices) == 1 {
+			base = aliasName
+		}
+		newIndexName, err := namer.name(base, oldIndex.Name)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		migrations[n] = indexMigration{oldIndex: oldIndex.Name, newIndex: newIndexName}
+		// New index takes over options such as is_write_index and filter from its old index
+		options, err := d.aliasOptions(ctx, oldIndex.Name, aliasName)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		actions = append(actions,
+			es.AliasAction{Type: es.AliasActionRemove, Index: oldIndex.Name, Alias: aliasName},
+			es.AliasAction{Type: es.AliasActionAdd, Index: newIndexName, Alias: aliasName, Options: options},
+		)
+	}
 
-	err = d.indexDomain.Copy(ctx, oldIndexName, newIndexName)
+	m := d.newMigrator(opt, migrations)
+	defer m.rollbackOnError(ctx, &err)
+
+	err = m.copy(ctx, detailJSON)
 	if err != nil {
 		return fail.Wrap(err)
 	}
-	err = d.esBaseClient.SwapAlias(ctx, aliasName, oldIndexName, newIndexName)
+
+	// Switch all indices at once
+	err = d.esBaseClient.UpdateAliases(ctx, actions)
 	if err != nil {
 		return fail.Wrap(err)
 	}
-	err = d.esBaseClient.DeleteIndex(ctx, oldIndexName)
+	m.commit()
+
+	if opt.KeepOld == 0 {
+		for _, oldIndex := range oldIndices {
+			err = d.esBaseClient.DeleteIndex(ctx, oldIndex.Name)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+		}
+	}
+	return fail.Wrap(d.retainOld(ctx, aliasName, migrations, opt.KeepOld))
+}
+
+// aliasOptions returns options of the alias on the index. e.g. {"is_write_index": true}
+func (d detailImpl) aliasOptions(ctx context.Context, indexName string, aliasName string) (map[string]interface{}, error) {
+	detail, err := d.esBaseClient.DetailIndex(ctx, indexName)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	aliases, _ := detail.Alias.(map[string]interface{})
+	options, _ := aliases[aliasName].(map[string]interface{})
+	return options, nil
+}
+
+func (d detailImpl) newIndexNamer(ctx context.Context, opt UpdateOpt) (*indexNamer, error) {
+	indices, err := d.esBaseClient.ListIndex(ctx)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	names := make([]string, len(indices))
+	for n, index := range indices {
+		names[n] = index.Name
+	}
+	return newIndexNamer(opt.NameTemplate, opt.SHA, names), nil
+}
+
+// retainOld records old indices for rollback when keep is positive, and deletes indices of generations older than keep.
+// Indices which were rolled back from are also deleted, because they are not a generation to rollback.
+func (d detailImpl) retainOld(ctx context.Context, aliasName string, migrations []indexMigration, keep int) error {
+	store := metadataStore{esBaseClient: d.esBaseClient}
+	history, err := store.aliasHistory(ctx, aliasName)
 	if err != nil {
 		return fail.Wrap(err)
 	}
+	if keep == 0 && len(history.RolledBack) == 0 {
+		return nil
+	}
+
+	for _, index := range history.RolledBack {
+		if updatedAgain(index, migrations) {
+			continue
+		}
+		zap.L().Info("Delete rolled back index", zap.String("index", index))
+		err = d.esBaseClient.DeleteIndex(ctx, index)
+		if err != nil && !es.IsNotFound(err) {
+			return fail.Wrap(err)
+		}
+	}
+	history.RolledBack = nil
+	if keep == 0 {
+		return fail.Wrap(store.putAliasHistory(ctx, history))
+	}
+
+	generation := aliasGeneration{UpdatedAt: time.Now()}
+	for _, migration := range migrations {
+		generation.Indices = append(generation.Indices, migration.oldIndex)
+		generation.ReplacedBy = append(generation.ReplacedBy, migration.newIndex)
+	}
+	history.Generations = append(history.Generations, generation)
+
+	for len(history.Generations) > keep {
+		for _, index := range history.Generations[0].Indices {
+			zap.L().Info("Delete old index", zap.String("index", index))
+			err = d.esBaseClient.DeleteIndex(ctx, index)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+		}
+		history.Generations = history.Generations[1:]
+	}
+	return fail.Wrap(store.putAliasHistory(ctx, history))
+}
+
+// updatedAgain returns whether the rolled back index is updated again. e.g. the alias is pointed to it by hand.
+// Then it is deleted or kept as an old index.
+func updatedAgain(index string, migrations []indexMigration) bool {
+	for _, migration := range migrations {
+		if migration.oldIndex == index || migration.newIndex == index {
+			return true
+		}
+	}
+	return false
+}
+
+// Rollback points the alias to the indices which it pointed to before the last update.
+// Current indices are kept until the next update, and recorded in the history.
+func (d detailImpl) Rollback(ctx context.Context, aliasName string) error {
+	store := metadataStore{esBaseClient: d.esBaseClient}
+	history, err := store.aliasHistory(ctx, aliasName)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	if len(history.Generations) == 0 {
+		return fail.New(fmt.Sprintf("Not found previous indices of alias %s. Update with --keep-old to rollback", aliasName))
+	}
+	previous := history.Generations[len(history.Generations)-1]
+
+	current, err := d.esBaseClient.ListAlias(ctx, aliasName)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	actions := []es.AliasAction{}
+	kept := []string{}
+	for _, index := range current {
+		actions = append(actions, es.AliasAction{Type: es.AliasActionRemove, Index: index.Name, Alias: aliasName})
+		kept = append(kept, index.Name)
+	}
+	for _, index := range previous.Indices {
+		// Previous indices are blocked when updated with --safe-mode block
+		err = setWriteBlock(ctx, d.esBaseClient, index, false)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		actions = append(actions, es.AliasAction{Type: es.AliasActionAdd, Index: index, Alias: aliasName})
+	}
+	err = d.esBaseClient.UpdateAliases(ctx, actions)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	zap.L().Info("Rollback", zap.String("alias", aliasName), zap.Strings("indices", previous.Indices), zap.Strings("kept", kept))
+
+	history.Generations = history.Generations[:len(history.Generations)-1]
+	history.RolledBack = append(history.RolledBack, kept...)
+	return fail.Wrap(store.putAliasHistory(ctx, history))
+}
+
+func (d detailImpl) Plan(ctx context.Context, name string, fp io.Reader) ([]IndexPlan, error) {
+	desired := es.IndexDetail{}
+	err := json.NewDecoder(fp).Decode(&desired)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	indices, err := d.esBaseClient.ListAlias(ctx, name)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	return d.plan(ctx, name, indices, desired)
+}
+
+func (d detailImpl) plan(ctx context.Context, name string, indices es.Indices, desired es.IndexDetail) ([]IndexPlan, error) {
+	indices = append(es.Indices{}, indices...)
+	sort.Slice(indices, func(i, j int) bool { return indices[i].Name < indices[j].Name })
+
+	plans := make([]IndexPlan, 0, len(indices))
+	for _, index := range indices {
+		current, err := d.esBaseClient.DetailIndex(ctx, index.Name)
+		if err != nil {
+			return nil, fail.Wrap(err)
+		}
+		plan, err := planDetail(index.Name, current, desired, name)
+		if err != nil {
+			return nil, fail.Wrap(err)
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// applyInPlace applies changes which do not need reindex. ignoreAlias is not added or removed.
+func (d detailImpl) applyInPlace(ctx context.Context, plan IndexPlan, desired es.IndexDetail, ignoreAlias string) error {
+	if len(plan.Changes) == 0 {
+		zap.L().Info("No changes", zap.String("index", plan.Index))
+		return nil
+	}
+	normalized, err := normalizeDetail(desired)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	settings := map[string]interface{}{}
+	updateMapping := false
+	updateAliases := false
+	for _, change := range plan.Changes {
+		switch {
+		case strings.HasPrefix(change.Path, "settings."):
+			// Settings which desired omits are kept. null of desired resets the setting to default
+			if change.Kind == ChangeRemove {
+				continue
+			}
+			settings[strings.TrimPrefix(change.Path, "settings.")] = change.New
+		case strings.HasPrefix(change.Path, "mappings."):
+			updateMapping = true
+		case strings.HasPrefix(change.Path, "aliases."):
+			updateAliases = true
+		}
+	}
+
+	if len(settings) > 0 {
+		err = d.putSettings(ctx, plan.Index, settings, plan.CloseOpen())
+		if err != nil {
+			return fail.Wrap(err)
+		}
+	}
+
+	if updateMapping {
+		mapping, err := json.Marshal(normalized.Mappings)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		zap.L().Info("Put mapping", zap.String("index", plan.Index))
+		err = d.esBaseClient.PutMapping(ctx, plan.Index, string(mapping))
+		if err != nil {
+			return fail.Wrap(err)
+		}
+	}
+
+	if updateAliases {
+		current, err := d.esBaseClient.DetailIndex(ctx, plan.Index)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		currentAliases, _ := current.Alias.(map[string]interface{})
+		actions := []es.AliasAction{}
+		for alias, options := range normalized.Aliases {
+			if alias == ignoreAlias || reflect.DeepEqual(currentAliases[alias], options) {
+				continue
+			}
+			// Add overwrites options of the existing alias
+			optionMap, _ := options.(map[string]interface{})
+			actions = append(actions, es.AliasAction{Type: es.AliasActionAdd, Index: plan.Index, Alias: alias, Options: optionMap})
+		}
+		for alias := range currentAliases {
+			if _, ok := normalized.Aliases[alias]; !ok && alias != ignoreAlias {
+				actions = append(actions, es.AliasAction{Type: es.AliasActionRemove, Index: plan.Index, Alias: alias})
+			}
+		}
+		err = d.esBaseClient.UpdateAliases(ctx, actions)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+	}
 	return nil
 }
+
+// putSettings updates settings. Static settings such as analysis are updated while the index is closed.
+func (d detailImpl) putSettings(ctx context.Context, indexName string, settings map[string]interface{}, closeIndex bool) (err error) {
+	body, err := json.Marshal(settings)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	if closeIndex {
+		zap.L().Warn("Index is not available until it is opened", zap.String("index", indexName))
+		err = d.esBaseClient.CloseIndex(ctx, indexName)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		defer func() {
+			// Open even if failed to put settings
+			openErr := d.esBaseClient.OpenIndex(ctx, indexName)
+			if err == nil {
+				err = fail.Wrap(openErr)
+			}
+		}()
+	}
+
+	zap.L().Info("Put settings", zap.String("index", indexName))
+	return fail.Wrap(d.esBaseClient.PutSettings(ctx, indexName, string(body)))
+}
+
+// serverManagedSettings are returned by DetailIndex, but CreateIndex rejects them.
+var serverManagedSettings = []string{"uuid", "creation_date", "version", "provided_name"}
+
+// creatableDetail returns a copy of detail which can be passed to CreateIndex.
+func creatableDetail(detail es.IndexDetail) (es.IndexDetail, error) {
+	b, err := json.Marshal(detail)
+	if err != nil {
+		return es.IndexDetail{}, fail.Wrap(err)
+	}
+	copied := es.IndexDetail{}
+	err = json.Unmarshal(b, &copied)
+	if err != nil {
+		return es.IndexDetail{}, fail.Wrap(err)
+	}
+
+	settings, ok := copied.Setting.(map[string]interface{})
+	if !ok {
+		return copied, nil
+	}
+	if indexSettings, ok := settings["index"].(map[string]interface{}); ok {
+		for _, key := range serverManagedSettings {
+			delete(indexSettings, key)
+		}
+	}
+	for _, key := range serverManagedSettings {
+		delete(settings, "index."+key)
+	}
+
+	return copied, nil
+}
+
+// mergeDetail returns desired which omitted settings, mappings and aliases are filled by current. ignoreAlias is not copied, because it is switched after copy.
+func mergeDetail(current es.IndexDetail, desired es.IndexDetail, ignoreAlias string) (string, error) {
+	creatable, err := creatableDetail(current)
+	if err != nil {
+		return "", fail.Wrap(err)
+	}
+	a, err := normalizeDetail(creatable)
+	if err != nil {
+		return "", fail.Wrap(err)
+	}
+	b, err := normalizeDetail(desired)
+	if err != nil {
+		return "", fail.Wrap(err)
+	}
+
+	settings := a.Settings
+	for key, value := range b.Settings {
+		settings[key] = value
+		// null resets the setting to default
+		if value == nil {
+			delete(settings, key)
+		}
+	}
+	merged := map[string]interface{}{"settings": settings, "mappings": b.Mappings, "aliases": b.Aliases}
+	if desired.Mapping == nil {
+		merged["mappings"] = a.Mappings
+	}
+	if desired.Alias == nil {
+		delete(a.Aliases, ignoreAlias)
+		merged["aliases"] = a.Aliases
+	}
+
+	body, err := json.Marshal(merged)
+	return string(body), fail.Wrap(err)
+}
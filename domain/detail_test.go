@@ -0,0 +1,470 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rerost/es-cli/infra/es"
+)
+
+func TestPortableDetail(t *testing.T) {
+	t.Parallel()
+
+	properties := map[string]interface{}{"properties": map[string]interface{}{"n": map[string]interface{}{"type": "long"}}}
+	settings := func() map[string]interface{} {
+		return map[string]interface{}{"index": map[string]interface{}{"number_of_shards": "1", "uuid": "u", "creation_date": "1", "provided_name": "orders", "version": map[string]interface{}{"created": "1"}}}
+	}
+	portableSettings := map[string]interface{}{"index": map[string]interface{}{"number_of_shards": "1"}}
+
+	inOuts := []struct {
+		name   string
+		detail es.IndexDetail
+		target string
+		want   es.IndexDetail
+	}{
+		{
+			name:   "Typed to typeless",
+			detail: es.IndexDetail{Setting: settings(), Mapping: map[string]interface{}{"doc": properties}},
+			target: "7.17.0",
+			want:   es.IndexDetail{Setting: portableSettings, Mapping: properties},
+		},
+		{
+			name:   "Typeless to typed",
+			detail: es.IndexDetail{Setting: settings(), Mapping: properties},
+			target: "6.8.0",
+			want:   es.IndexDetail{Setting: portableSettings, Mapping: map[string]interface{}{"_doc": properties}},
+		},
+		{
+			name:   "Keep the type name",
+			detail: es.IndexDetail{Setting: settings(), Mapping: map[string]interface{}{"doc": properties}},
+			target: "6.8.0",
+			want:   es.IndexDetail{Setting: portableSettings, Mapping: map[string]interface{}{"doc": properties}},
+		},
+	}
+
+	for _, inOut := range inOuts {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := portableDetail(inOut.detail, es.ServerInfo{Version: es.Version{Number: inOut.target}, Distribution: es.DistributionElasticsearch})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(inOut.want, got); diff != "" {
+				t.Errorf("Not match detail, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+
+	detail := `{"settings": {"index": {"number_of_shards": 2}}, "mappings": {"properties": {"n": {"type": "long"}}}}`
+	current := func(index string) string {
+		return fmt.Sprintf(`{"%s": {"aliases": {}, "mappings": {"properties": {"n": {"type": "long"}}}, "settings": {"index": {"number_of_shards": "1"}}}}`, index)
+	}
+	copied := map[string][]fakeResponse{
+		"POST /_reindex":     respond(`{"task": "t1"}`),
+		"GET /_tasks/t1":     respond(`{"completed": true, "response": {"total": 1}}`),
+		"GET /orders/_count": respond(`{"count": 1}`),
+	}
+
+	type InOutPairs struct {
+		name      string
+		responses map[string][]fakeResponse
+		want      []string
+		actions   string
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name: "Index is replaced with an alias at once",
+			responses: map[string][]fakeResponse{
+				"GET /orders":           respond(current("orders")),
+				"GET /_aliases":         respond(`{"orders": {"aliases": {}}}`, `{"orders": {"aliases": {}}, "orders_v2": {"aliases": {}}}`),
+				"PUT /orders_v2":        respond(`{"acknowledged": true}`),
+				"GET /orders_v2/_count": respond(`{"count": 1}`),
+				"POST /_aliases":        respond(`{"acknowledged": true}`),
+			},
+			want: []string{
+				"GET /orders", "GET /orders", "GET /orders", "GET /_aliases",
+				"PUT /orders_v2", "GET /_aliases", "POST /_reindex", "GET /_tasks/t1", "GET /orders/_count", "GET /orders_v2/_count",
+				"POST /_aliases",
+			},
+			actions: `{"actions":[{"add":{"alias":"orders","index":"orders_v2"}},{"remove_index":{"index":"orders"}}]}`,
+		},
+		{
+			name: "Alias is switched, then the old index is deleted",
+			responses: map[string][]fakeResponse{
+				"GET /orders":           respond(current("orders_v1")),
+				"GET /orders_v1":        respond(current("orders_v1")),
+				"GET /_aliases":         respond(`{"orders_v1": {"aliases": {"orders": {}}}}`, `{"orders_v1": {"aliases": {"orders": {}}}, "orders_v2": {"aliases": {}}}`),
+				"PUT /orders_v2":        respond(`{"acknowledged": true}`),
+				"GET /orders_v1/_count": respond(`{"count": 1}`),
+				"GET /orders_v2/_count": respond(`{"count": 1}`),
+				"POST /_aliases":        respond(`{"acknowledged": true}`),
+				"DELETE /orders_v1":     respond(`{"acknowledged": true}`),
+			},
+			want: []string{
+				"GET /orders", "GET /orders_v1", "GET /orders_v1", "GET /_aliases", "GET /orders_v1",
+				"PUT /orders_v2", "GET /_aliases", "POST /_reindex", "GET /_tasks/t1", "GET /orders_v1/_count", "GET /orders_v2/_count",
+				"POST /_aliases", "DELETE /orders_v1", "GET /es-cli-metadata/_doc/alias:orders",
+			},
+			actions: `{"actions":[{"remove":{"alias":"orders","index":"orders_v1"}},{"add":{"alias":"orders","index":"orders_v2"}}]}`,
+		},
+		{
+			name: "Alias options of each old index are kept",
+			responses: map[string][]fakeResponse{
+				"GET /orders":      respond(`{"orders_a_v1": {"aliases": {"orders": {}}}, "orders_b_v1": {"aliases": {"orders": {}}}}`),
+				"GET /orders_a_v1": respond(`{"orders_a_v1": {"aliases": {"orders": {"is_write_index": true, "filter": {"term": {"shop": "a"}}}}, "mappings": {"properties": {"n": {"type": "long"}}}, "settings": {"index": {"number_of_shards": "1"}}}}`),
+				"GET /orders_b_v1": respond(`{"orders_b_v1": {"aliases": {"orders": {"is_write_index": false, "filter": {"term": {"shop": "b"}}}}, "mappings": {"properties": {"n": {"type": "long"}}}, "settings": {"index": {"number_of_shards": "1"}}}}`),
+				"GET /_aliases": respond(
+					`{"orders_a_v1": {"aliases": {"orders": {}}}, "orders_b_v1": {"aliases": {"orders": {}}}}`,
+					`{"orders_a_v1": {"aliases": {"orders": {}}}, "orders_b_v1": {"aliases": {"orders": {}}}, "orders_a_v2": {"aliases": {}}, "orders_b_v2": {"aliases": {}}}`,
+				),
+				"PUT /orders_a_v2":        respond(`{"acknowledged": true}`),
+				"PUT /orders_b_v2":        respond(`{"acknowledged": true}`),
+				"GET /orders_a_v1/_count": respond(`{"count": 1}`),
+				"GET /orders_a_v2/_count": respond(`{"count": 1}`),
+				"GET /orders_b_v1/_count": respond(`{"count": 1}`),
+				"GET /orders_b_v2/_count": respond(`{"count": 1}`),
+				"POST /_aliases":          respond(`{"acknowledged": true}`),
+				"DELETE /orders_a_v1":     respond(`{"acknowledged": true}`),
+				"DELETE /orders_b_v1":     respond(`{"acknowledged": true}`),
+			},
+			want: []string{
+				"GET /orders", "GET /orders_a_v1", "GET /orders_b_v1", "GET /orders_a_v1", "GET /_aliases", "GET /orders_a_v1", "GET /orders_b_v1",
+				"PUT /orders_a_v2", "PUT /orders_b_v2",
+				"GET /_aliases", "POST /_reindex", "GET /_tasks/t1", "GET /orders_a_v1/_count", "GET /orders_a_v2/_count",
+				"GET /_aliases", "POST /_reindex", "GET /_tasks/t1", "GET /orders_b_v1/_count", "GET /orders_b_v2/_count",
+				"POST /_aliases", "DELETE /orders_a_v1", "DELETE /orders_b_v1", "GET /es-cli-metadata/_doc/alias:orders",
+			},
+			actions: `{"actions":[` +
+				`{"remove":{"alias":"orders","index":"orders_a_v1"}},{"add":{"alias":"orders","filter":{"term":{"shop":"a"}},"index":"orders_a_v2","is_write_index":true}},` +
+				`{"remove":{"alias":"orders","index":"orders_b_v1"}},{"add":{"alias":"orders","filter":{"term":{"shop":"b"}},"index":"orders_b_v2","is_write_index":false}}]}`,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			responses := map[string][]fakeResponse{}
+			for key, response := range copied {
+				responses[key] = response
+			}
+			for key, response := range inOut.responses {
+				responses[key] = response
+			}
+			server, baseClient := newFakeServer(t, "7.17.0", responses)
+			detailDomain := NewDetail(baseClient, NewIndex(baseClient))
+
+			err := detailDomain.Update(context.Background(), "orders", strings.NewReader(detail), UpdateOpt{NameTemplate: NameVersion})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(inOut.want, server.calls()); diff != "" {
+				t.Errorf("Not match requests, diff(-want, +got) %s", diff)
+			}
+			if diff := cmp.Diff([]string{inOut.actions}, server.bodies("POST /_aliases")); diff != "" {
+				t.Errorf("Not match alias actions, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestMergeDetail(t *testing.T) {
+	t.Parallel()
+
+	current := es.IndexDetail{
+		Setting: map[string]interface{}{"index": map[string]interface{}{"number_of_shards": "1", "refresh_interval": "1s", "uuid": "u", "analysis": map[string]interface{}{"analyzer": map[string]interface{}{"a": map[string]interface{}{"type": "standard"}}}}},
+		Mapping: map[string]interface{}{"_doc": map[string]interface{}{"properties": map[string]interface{}{"n": map[string]interface{}{"type": "long"}}}},
+		Alias:   map[string]interface{}{"orders": map[string]interface{}{}, "orders_read": map[string]interface{}{}},
+	}
+
+	type InOutPairs struct {
+		name    string
+		desired es.IndexDetail
+		want    string
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name:    "Omitted settings, mappings and aliases are kept",
+			desired: es.IndexDetail{Setting: map[string]interface{}{"number_of_shards": 2, "refresh_interval": nil}},
+			want:    `{"aliases":{"orders_read":{}},"mappings":{"properties":{"n":{"type":"long"}}},"settings":{"index.analysis.analyzer.a.type":"standard","index.number_of_shards":"2"}}`,
+		},
+		{
+			name:    "Mappings and aliases are replaced",
+			desired: es.IndexDetail{Mapping: map[string]interface{}{"properties": map[string]interface{}{}}, Alias: map[string]interface{}{}},
+			want:    `{"aliases":{},"mappings":{"properties":{}},"settings":{"index.analysis.analyzer.a.type":"standard","index.number_of_shards":"1","index.refresh_interval":"1s"}}`,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := mergeDetail(current, inOut.desired, "orders")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != inOut.want {
+				t.Errorf("want %s, got %s", inOut.want, got)
+			}
+		})
+	}
+}
+
+func TestApplyInPlace(t *testing.T) {
+	t.Parallel()
+
+	current := `{
+		"settings": {"index": {"number_of_shards": "1", "number_of_replicas": "1", "refresh_interval": "1s", "analysis": {"analyzer": {"a": {"type": "standard"}}}}},
+		"mappings": {"properties": {"n": {"type": "long"}}}
+	}`
+
+	type InOutPairs struct {
+		name     string
+		desired  string
+		settings fakeResponse
+		want     []string
+		bodies   []string
+		hasError bool
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name:    "Only settings of desired are put",
+			desired: `{"settings": {"number_of_replicas": 2}}`,
+			want:    []string{"PUT /orders_v1/_settings"},
+			bodies:  []string{`{"index.number_of_replicas":"2"}`},
+		},
+		{
+			name:    "Analysis is put while the index is closed",
+			desired: `{"settings": {"analysis": {"analyzer": {"a": {"type": "simple"}}}}}`,
+			want:    []string{"POST /orders_v1/_close", "PUT /orders_v1/_settings", "POST /orders_v1/_open"},
+			bodies:  []string{`{"index.analysis.analyzer.a.type":"simple"}`},
+		},
+		{
+			name:     "Index is opened even if it failed to put settings",
+			desired:  `{"settings": {"analysis": {"analyzer": {"a": {"type": "simple"}}}}}`,
+			settings: fakeResponse{status: 400, body: `{"error": "invalid"}`},
+			want:     []string{"POST /orders_v1/_close", "PUT /orders_v1/_settings", "POST /orders_v1/_open"},
+			bodies:   []string{`{"index.analysis.analyzer.a.type":"simple"}`},
+			hasError: true,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			a := es.IndexDetail{}
+			if err := json.Unmarshal([]byte(current), &a); err != nil {
+				t.Fatal(err)
+			}
+			b := es.IndexDetail{}
+			if err := json.Unmarshal([]byte(inOut.desired), &b); err != nil {
+				t.Fatal(err)
+			}
+			plan, err := planDetail("orders_v1", a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			settings := inOut.settings
+			if settings.body == "" {
+				settings.body = `{"acknowledged": true}`
+			}
+			server, baseClient := newFakeServer(t, "7.17.0", map[string][]fakeResponse{
+				"POST /orders_v1/_close":   respond(`{"acknowledged": true}`),
+				"PUT /orders_v1/_settings": {settings},
+				"POST /orders_v1/_open":    respond(`{"acknowledged": true}`),
+			})
+			d := detailImpl{esBaseClient: baseClient}
+
+			err = d.applyInPlace(context.Background(), plan, b, "orders")
+			if (err != nil) != inOut.hasError {
+				t.Fatalf("hasError want %v, got %v", inOut.hasError, err)
+			}
+			if diff := cmp.Diff(inOut.want, server.calls()); diff != "" {
+				t.Errorf("Not match requests, diff(-want, +got) %s", diff)
+			}
+			if diff := cmp.Diff(inOut.bodies, server.bodies("PUT /orders_v1/_settings")); diff != "" {
+				t.Errorf("Not match settings, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	t.Parallel()
+
+	server, baseClient := newFakeServer(t, "7.17.0", map[string][]fakeResponse{
+		"GET /es-cli-metadata/_doc/alias:orders": respond(`{"found": true, "_source": {"alias": "orders", "generations": [{"indices": ["orders_v1"], "replaced_by": ["orders_v2"]}]}}`),
+		"PUT /es-cli-metadata/_doc/alias:orders": respond(`{"result": "updated"}`),
+		"GET /orders":                            respond(`{"orders_v2": {"aliases": {"orders": {}}}}`),
+		"PUT /orders_v1/_settings":               respond(`{"acknowledged": true}`),
+		"POST /_aliases":                         respond(`{"acknowledged": true}`),
+	})
+	detailDomain := NewDetail(baseClient, NewIndex(baseClient))
+
+	err := detailDomain.Rollback(context.Background(), "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"GET /es-cli-metadata/_doc/alias:orders", "GET /orders", "PUT /orders_v1/_settings", "POST /_aliases", "PUT /es-cli-metadata/_doc/alias:orders",
+	}
+	if diff := cmp.Diff(want, server.calls()); diff != "" {
+		t.Errorf("Not match requests, diff(-want, +got) %s", diff)
+	}
+	if diff := cmp.Diff([]string{`{"index.blocks.write":false}`}, server.bodies("PUT /orders_v1/_settings")); diff != "" {
+		t.Errorf("Previous index must be unblocked, diff(-want, +got) %s", diff)
+	}
+	actions := `{"actions":[{"remove":{"alias":"orders","index":"orders_v2"}},{"add":{"alias":"orders","index":"orders_v1"}}]}`
+	if diff := cmp.Diff([]string{actions}, server.bodies("POST /_aliases")); diff != "" {
+		t.Errorf("Not match alias actions, diff(-want, +got) %s", diff)
+	}
+	// The index which is rolled back from is recorded, so that the next update deletes it
+	history := `{"alias":"orders","generations":[],"rolled_back":["orders_v2"]}`
+	if diff := cmp.Diff([]string{history}, server.bodies("PUT /es-cli-metadata/_doc/alias:orders")); diff != "" {
+		t.Errorf("Not match history, diff(-want, +got) %s", diff)
+	}
+}
+
+func TestRetainOld(t *testing.T) {
+	t.Parallel()
+
+	migrations := []indexMigration{{oldIndex: "orders_v1", newIndex: "orders_v3"}}
+
+	type InOutPairs struct {
+		name        string
+		history     string
+		keep        int
+		want        []string
+		generations [][]string
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name:    "Nothing is recorded without keep",
+			history: `{"found": false}`,
+			keep:    0,
+			want:    []string{"GET /es-cli-metadata/_doc/alias:orders"},
+		},
+		{
+			name:    "Rolled back index is deleted without keep",
+			history: `{"found": true, "_source": {"alias": "orders", "rolled_back": ["orders_v2"]}}`,
+			keep:    0,
+			want:    []string{"GET /es-cli-metadata/_doc/alias:orders", "DELETE /orders_v2", "PUT /es-cli-metadata/_doc/alias:orders"},
+		},
+		{
+			name:        "Rolled back index and older generations are deleted",
+			history:     `{"found": true, "_source": {"alias": "orders", "generations": [{"indices": ["orders_v0"]}], "rolled_back": ["orders_v2"]}}`,
+			keep:        1,
+			want:        []string{"GET /es-cli-metadata/_doc/alias:orders", "DELETE /orders_v2", "DELETE /orders_v0", "PUT /es-cli-metadata/_doc/alias:orders"},
+			generations: [][]string{{"orders_v1"}},
+		},
+		{
+			name:        "Rolled back index which is updated again is kept as an old index",
+			history:     `{"found": true, "_source": {"alias": "orders", "rolled_back": ["orders_v1"]}}`,
+			keep:        2,
+			want:        []string{"GET /es-cli-metadata/_doc/alias:orders", "PUT /es-cli-metadata/_doc/alias:orders"},
+			generations: [][]string{{"orders_v1"}},
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			server, baseClient := newFakeServer(t, "7.17.0", map[string][]fakeResponse{
+				"GET /es-cli-metadata/_doc/alias:orders": respond(inOut.history),
+				"PUT /es-cli-metadata/_doc/alias:orders": respond(`{"result": "updated"}`),
+				"DELETE /orders_v0":                      respond(`{"acknowledged": true}`),
+				"DELETE /orders_v2":                      respond(`{"acknowledged": true}`),
+			})
+			d := detailImpl{esBaseClient: baseClient}
+
+			err := d.retainOld(context.Background(), "orders", migrations, inOut.keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(inOut.want, server.calls()); diff != "" {
+				t.Errorf("Not match requests, diff(-want, +got) %s", diff)
+			}
+
+			saved := server.bodies("PUT /es-cli-metadata/_doc/alias:orders")
+			if len(saved) == 0 {
+				return
+			}
+			history := aliasHistory{}
+			if err := json.Unmarshal([]byte(saved[0]), &history); err != nil {
+				t.Fatal(err)
+			}
+			var generations [][]string
+			for _, generation := range history.Generations {
+				generations = append(generations, generation.Indices)
+			}
+			if diff := cmp.Diff(inOut.generations, generations); diff != "" {
+				t.Errorf("Not match generations, diff(-want, +got) %s", diff)
+			}
+			if len(history.RolledBack) != 0 {
+				t.Errorf("Rolled back indices must be cleared, got %v", history.RolledBack)
+			}
+		})
+	}
+}
+
+func TestCreatableDetail(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name string
+		in   string
+		want string
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name: "Nested settings",
+			in:   `{"settings": {"index": {"number_of_shards": "1", "uuid": "u", "creation_date": "1", "provided_name": "orders", "version": {"created": "1"}}}, "mappings": {"properties": {}}, "aliases": {"orders_read": {}}}`,
+			want: `{"settings":{"index":{"number_of_shards":"1"}},"aliases":{"orders_read":{}},"mappings":{"properties":{}}}`,
+		},
+		{
+			name: "Flattened settings",
+			in:   `{"settings": {"index.number_of_shards": "1", "index.uuid": "u", "index.creation_date": "1", "index.provided_name": "orders", "index.version": {"created": "1"}}}`,
+			want: `{"settings":{"index.number_of_shards":"1"},"aliases":null,"mappings":null}`,
+		},
+		{
+			name: "Without settings",
+			in:   `{"mappings": {"properties": {}}}`,
+			want: `{"settings":null,"aliases":null,"mappings":{"properties":{}}}`,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			detail := es.IndexDetail{}
+			if err := json.Unmarshal([]byte(inOut.in), &detail); err != nil {
+				t.Fatal(err)
+			}
+			before := detail.String()
+
+			got, err := creatableDetail(detail)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(inOut.want, got.String()); diff != "" {
+				t.Errorf("Not match detail, diff(-want, +got) %s", diff)
+			}
+			if detail.String() != before {
+				t.Errorf("Detail must not be modified, got %s", detail.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rerost/es-cli/infra/es"
+	"github.com/srvc/fail"
+)
+
+const (
+	ChangeAdd    = "add"
+	ChangeRemove = "remove"
+	ChangeModify = "modify"
+
+	// ApplyInPlace is applied by _mapping, _settings or _aliases
+	ApplyInPlace = "in-place"
+	// ApplyCloseOpen is applied by _settings while the index is closed
+	ApplyCloseOpen = "close/open"
+	// ApplyReindex needs a new index and reindex
+	ApplyReindex = "reindex"
+)
+
+// Change is a difference of a field. Path is dotted. e.g. mappings.properties.title.type
+type Change struct {
+	Path  string
+	Kind  string
+	Old   interface{}
+	New   interface{}
+	Apply string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s (%s)", c.Diff(), c.Apply)
+}
+
+// Diff returns the change without how it is applied.
+func (c Change) Diff() string {
+	switch c.Kind {
+	case ChangeAdd:
+		return fmt.Sprintf("+ %s: %s", c.Path, jsonString(c.New))
+	case ChangeRemove:
+		return fmt.Sprintf("- %s: %s", c.Path, jsonString(c.Old))
+	default:
+		return fmt.Sprintf("~ %s: %s => %s", c.Path, jsonString(c.Old), jsonString(c.New))
+	}
+}
+
+func jsonString(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// IndexPlan is changes from the current detail of an index to the desired detail.
+type IndexPlan struct {
+	Index   string
+	Changes []Change
+}
+
+// Reindex returns whether any change needs reindex.
+func (p IndexPlan) Reindex() bool {
+	return p.needs(ApplyReindex)
+}
+
+// CloseOpen returns whether any change needs to close the index.
+func (p IndexPlan) CloseOpen() bool {
+	return p.needs(ApplyCloseOpen)
+}
+
+func (p IndexPlan) needs(apply string) bool {
+	for _, change := range p.Changes {
+		if change.Apply == apply {
+			return true
+		}
+	}
+	return false
+}
+
+func (p IndexPlan) String() string {
+	lines := []string{fmt.Sprintf("Index: %s", p.Index)}
+	for _, change := range p.Changes {
+		lines = append(lines, "  "+change.String())
+	}
+	switch {
+	case len(p.Changes) == 0:
+		lines = append(lines, "No changes")
+	case p.Reindex():
+		lines = append(lines, fmt.Sprintf("%d changes. Reindex is required", len(p.Changes)))
+	case p.CloseOpen():
+		lines = append(lines, fmt.Sprintf("%d changes. Can be applied in place, but the index is closed while updating", len(p.Changes)))
+	default:
+		lines = append(lines, fmt.Sprintf("%d changes. Can be applied in place", len(p.Changes)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// normalizedDetail is a detail which can be compared.
+// Settings are flattened to "index.*" keys with string values, because Elasticsearch returns settings as strings.
+// Mappings are typeless.
+type normalizedDetail struct {
+	Settings map[string]interface{}
+	Mappings map[string]interface{}
+	Aliases  map[string]interface{}
+}
+
+func normalizeDetail(detail es.IndexDetail) (normalizedDetail, error) {
+	// Deep copy to avoid modifying detail
+	b, err := json.Marshal(detail)
+	if err != nil {
+		return normalizedDetail{}, fail.Wrap(err)
+	}
+	copied := struct {
+		Settings map[string]interface{} `json:"settings"`
+		Mappings map[string]interface{} `json:"mappings"`
+		Aliases  map[string]interface{} `json:"aliases"`
+	}{}
+	err = json.Unmarshal(b, &copied)
+	if err != nil {
+		return normalizedDetail{}, fail.Wrap(err)
+	}
+
+	normalized := normalizedDetail{
+		Settings: map[string]interface{}{},
+		Mappings: typelessMapping(copied.Mappings),
+		Aliases:  copied.Aliases,
+	}
+	flattenSettings(normalized.Settings, "", copied.Settings)
+	for key := range normalized.Settings {
+		if isServerManagedSetting(key) {
+			delete(normalized.Settings, key)
+		}
+	}
+	if normalized.Mappings == nil {
+		normalized.Mappings = map[string]interface{}{}
+	}
+	if normalized.Aliases == nil {
+		normalized.Aliases = map[string]interface{}{}
+	}
+	return normalized, nil
+}
+
+func flattenSettings(result map[string]interface{}, prefix string, settings map[string]interface{}) {
+	for key, value := range settings {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flattenSettings(result, path, v)
+		default:
+			if !strings.HasPrefix(path, "index.") {
+				path = "index." + path
+			}
+			result[path] = settingValue(v)
+		}
+	}
+}
+
+func settingValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for n, element := range v {
+			values[n] = settingValue(element)
+		}
+		return values
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return nil
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func isServerManagedSetting(key string) bool {
+	for _, managed := range serverManagedSettings {
+		if key == "index."+managed || strings.HasPrefix(key, "index."+managed+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func typelessMapping(mappings map[string]interface{}) map[string]interface{} {
+	if typeName, ok := es.MappingType(mappings); ok {
+		return mappings[typeName].(map[string]interface{})
+	}
+	return mappings
+}
+
+// diffValue returns changes from a to b. Objects are compared by keys, other values are compared as a whole.
+func diffValue(path string, a, b interface{}) []Change {
+	objectA, okA := a.(map[string]interface{})
+	objectB, okB := b.(map[string]interface{})
+	if !okA || !okB {
+		if reflect.DeepEqual(a, b) {
+			return nil
+		}
+		return []Change{{Path: path, Kind: ChangeModify, Old: a, New: b}}
+	}
+
+	keys := map[string]bool{}
+	for key := range objectA {
+		keys[key] = true
+	}
+	for key := range objectB {
+		keys[key] = true
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	changes := []Change{}
+	for _, key := range sortedKeys {
+		childPath := path + "." + key
+		valueA, inA := objectA[key]
+		valueB, inB := objectB[key]
+		switch {
+		case !inA:
+			changes = append(changes, Change{Path: childPath, Kind: ChangeAdd, New: valueB})
+		case !inB:
+			changes = append(changes, Change{Path: childPath, Kind: ChangeRemove, Old: valueA})
+		default:
+			changes = append(changes, diffValue(childPath, valueA, valueB)...)
+		}
+	}
+	return changes
+}
+
+// dynamicSettings can be updated by _settings on an open index.
+var dynamicSettings = []string{
+	"index.number_of_replicas", "index.auto_expand_replicas", "index.refresh_interval",
+	"index.max_result_window", "index.max_inner_result_window", "index.max_rescore_window",
+	"index.max_docvalue_fields_search", "index.max_script_fields", "index.max_ngram_diff",
+	"index.max_shingle_diff", "index.max_refresh_listeners", "index.max_terms_count",
+	"index.max_regex_length", "index.default_pipeline", "index.final_pipeline", "index.hidden",
+	"index.gc_deletes", "index.query.default_field", "index.highlight.max_analyzed_offset",
+	"index.blocks.", "index.routing.", "index.search.", "index.indexing.", "index.translog.",
+	"index.unassigned.", "index.mapping.", "index.lifecycle.", "index.plugins.", "index.opendistro.",
+}
+
+// classify returns how the change is applied.
+func classify(change Change) string {
+	switch {
+	case strings.HasPrefix(change.Path, "aliases."):
+		return ApplyInPlace
+	case strings.HasPrefix(change.Path, "settings."):
+		key := strings.TrimPrefix(change.Path, "settings.")
+		for _, setting := range dynamicSettings {
+			if key == setting || (strings.HasSuffix(setting, ".") && strings.HasPrefix(key, setting)) {
+				return ApplyInPlace
+			}
+		}
+		// Analysis settings can be updated only while the index is closed
+		if strings.HasPrefix(key, "index.analysis.") {
+			return ApplyCloseOpen
+		}
+		return ApplyReindex
+	case strings.HasPrefix(change.Path, "mappings."):
+		segments := strings.Split(change.Path, ".")
+		last := segments[len(segments)-1]
+		if change.Kind == ChangeAdd && len(segments) >= 2 {
+			// New fields, multi-fields and properties of objects
+			switch segments[len(segments)-2] {
+			case "properties", "fields":
+				return ApplyInPlace
+			}
+			if last == "fields" {
+				return ApplyInPlace
+			}
+		}
+		switch last {
+		case "ignore_above", "dynamic", "search_analyzer", "search_quote_analyzer":
+			return ApplyInPlace
+		}
+		if len(segments) >= 2 {
+			switch segments[1] {
+			case "_meta", "dynamic_templates", "runtime":
+				return ApplyInPlace
+			}
+		}
+		return ApplyReindex
+	default:
+		return ApplyReindex
+	}
+}
+
+// planDetail returns changes from current to desired. Aliases in ignoreAliases are managed by update, so they are not compared.
+func planDetail(indexName string, current es.IndexDetail, desired es.IndexDetail, ignoreAliases ...string) (IndexPlan, error) {
+	a, err := normalizeDetail(current)
+	if err != nil {
+		return IndexPlan{}, fail.Wrap(err)
+	}
+	b, err := normalizeDetail(desired)
+	if err != nil {
+		return IndexPlan{}, fail.Wrap(err)
+	}
+	for _, alias := range ignoreAliases {
+		delete(a.Aliases, alias)
+		delete(b.Aliases, alias)
+	}
+	// Settings which desired omits are kept as they are, because the server returns defaults such as number_of_shards. null resets the setting
+	for key := range a.Settings {
+		if _, ok := b.Settings[key]; !ok {
+			delete(a.Settings, key)
+		}
+	}
+
+	changes := []Change{}
+	changes = append(changes, diffValue("settings", a.Settings, b.Settings)...)
+	// Mappings and aliases are not managed when desired does not have them
+	if desired.Mapping != nil {
+		changes = append(changes, diffValue("mappings", a.Mappings, b.Mappings)...)
+	}
+	if desired.Alias != nil {
+		changes = append(changes, diffValue("aliases", a.Aliases, b.Aliases)...)
+	}
+	for n := range changes {
+		changes[n].Apply = classify(changes[n])
+	}
+	return IndexPlan{Index: indexName, Changes: changes}, nil
+}
@@ -0,0 +1,147 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rerost/es-cli/infra/es"
+)
+
+func TestPlanDetail(t *testing.T) {
+	t.Parallel()
+
+	current := `{
+		"settings": {"index": {"number_of_shards": "1", "number_of_replicas": "1", "refresh_interval": "1s", "uuid": "u", "analysis": {"analyzer": {"a": {"type": "standard"}}}}},
+		"mappings": {"_doc": {"properties": {"name": {"type": "keyword", "ignore_above": 256}}}},
+		"aliases": {"orders_read": {}}
+	}`
+
+	type InOutPairs struct {
+		name    string
+		desired string
+		want    []Change
+		reindex bool
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name:    "Omitted settings, mappings and aliases are kept",
+			desired: `{"settings": {"number_of_replicas": 1}}`,
+			want:    []Change{},
+		},
+		{
+			name:    "Dynamic setting",
+			desired: `{"settings": {"number_of_replicas": 2}}`,
+			want: []Change{
+				{Path: "settings.index.number_of_replicas", Kind: ChangeModify, Old: "1", New: "2", Apply: ApplyInPlace},
+			},
+		},
+		{
+			name:    "null resets a setting",
+			desired: `{"settings": {"index": {"refresh_interval": null}}, "mappings": {"properties": {"name": {"type": "keyword", "ignore_above": 256}}}}`,
+			want: []Change{
+				{Path: "settings.index.refresh_interval", Kind: ChangeModify, Old: "1s", New: nil, Apply: ApplyInPlace},
+			},
+		},
+		{
+			name:    "Static setting",
+			desired: `{"settings": {"number_of_shards": 2}, "mappings": {"properties": {"name": {"type": "keyword", "ignore_above": 256}}}}`,
+			want: []Change{
+				{Path: "settings.index.number_of_shards", Kind: ChangeModify, Old: "1", New: "2", Apply: ApplyReindex},
+			},
+			reindex: true,
+		},
+		{
+			name:    "Analysis",
+			desired: `{"settings": {"analysis": {"analyzer": {"a": {"type": "simple"}}}}, "mappings": {"properties": {"name": {"type": "keyword", "ignore_above": 256}}}}`,
+			want: []Change{
+				{Path: "settings.index.analysis.analyzer.a.type", Kind: ChangeModify, Old: "standard", New: "simple", Apply: ApplyCloseOpen},
+			},
+		},
+		{
+			name:    "Mappings",
+			desired: `{"mappings": {"properties": {"name": {"type": "keyword", "ignore_above": 100}, "price": {"type": "long"}}}}`,
+			want: []Change{
+				{Path: "mappings.properties.name.ignore_above", Kind: ChangeModify, Old: 256.0, New: 100.0, Apply: ApplyInPlace},
+				{Path: "mappings.properties.price", Kind: ChangeAdd, New: map[string]interface{}{"type": "long"}, Apply: ApplyInPlace},
+			},
+		},
+		{
+			name:    "Type of field",
+			desired: `{"mappings": {"properties": {"name": {"type": "text", "ignore_above": 256}}}}`,
+			want: []Change{
+				{Path: "mappings.properties.name.type", Kind: ChangeModify, Old: "keyword", New: "text", Apply: ApplyReindex},
+			},
+			reindex: true,
+		},
+		{
+			name:    "Aliases",
+			desired: `{"mappings": {"properties": {"name": {"type": "keyword", "ignore_above": 256}}}, "aliases": {"orders_write": {}}}`,
+			want: []Change{
+				{Path: "aliases.orders_read", Kind: ChangeRemove, Old: map[string]interface{}{}, Apply: ApplyInPlace},
+				{Path: "aliases.orders_write", Kind: ChangeAdd, New: map[string]interface{}{}, Apply: ApplyInPlace},
+			},
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			a := es.IndexDetail{}
+			if err := json.Unmarshal([]byte(current), &a); err != nil {
+				t.Fatal(err)
+			}
+			b := es.IndexDetail{}
+			if err := json.Unmarshal([]byte(inOut.desired), &b); err != nil {
+				t.Fatal(err)
+			}
+
+			plan, err := planDetail("orders", a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(inOut.want, plan.Changes); diff != "" {
+				t.Errorf("Not match changes, diff(-want, +got) %s", diff)
+			}
+			if plan.Reindex() != inOut.reindex {
+				t.Errorf("Reindex want %v, got %v", inOut.reindex, plan.Reindex())
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		change Change
+		want   string
+	}
+	inOutPairs := []InOutPairs{
+		{change: Change{Path: "settings.index.number_of_replicas", Kind: ChangeModify}, want: ApplyInPlace},
+		{change: Change{Path: "settings.index.routing.allocation.include._name", Kind: ChangeAdd}, want: ApplyInPlace},
+		{change: Change{Path: "settings.index.number_of_shards", Kind: ChangeModify}, want: ApplyReindex},
+		{change: Change{Path: "settings.index.codec", Kind: ChangeModify}, want: ApplyReindex},
+		{change: Change{Path: "settings.index.analysis.filter.f.type", Kind: ChangeModify}, want: ApplyCloseOpen},
+		{change: Change{Path: "mappings.properties.user.properties.name", Kind: ChangeAdd}, want: ApplyInPlace},
+		{change: Change{Path: "mappings.properties.name.fields", Kind: ChangeAdd}, want: ApplyInPlace},
+		{change: Change{Path: "mappings.properties.name.fields.raw", Kind: ChangeAdd}, want: ApplyInPlace},
+		{change: Change{Path: "mappings.properties.name", Kind: ChangeRemove}, want: ApplyReindex},
+		{change: Change{Path: "mappings.properties.name.analyzer", Kind: ChangeModify}, want: ApplyReindex},
+		{change: Change{Path: "mappings.properties.name.search_analyzer", Kind: ChangeModify}, want: ApplyInPlace},
+		{change: Change{Path: "mappings._meta.version", Kind: ChangeModify}, want: ApplyInPlace},
+		{change: Change{Path: "mappings.dynamic", Kind: ChangeModify}, want: ApplyInPlace},
+		{change: Change{Path: "aliases.orders_read", Kind: ChangeRemove}, want: ApplyInPlace},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.change.Path, func(t *testing.T) {
+			t.Parallel()
+			if got := classify(inOut.change); got != inOut.want {
+				t.Errorf("want %s, got %s", inOut.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package domain
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rerost/es-cli/infra/es"
+	"github.com/srvc/fail"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatTSV   = "tsv"
+	FormatJSONL = "jsonl"
+
+	// ArrayJoin joins elements with separator. e.g. a|b
+	ArrayJoin = "join"
+	// ArrayJSON writes array as JSON. e.g. ["a","b"]
+	ArrayJSON = "json"
+	// ArrayFirst writes only the first element
+	ArrayFirst = "first"
+	// ArrayIndex expands elements to fields. e.g. tags.0, tags.1
+	ArrayIndex = "index"
+
+	idField = "_id"
+)
+
+type ExportOpt struct {
+	// Format is csv, tsv or jsonl
+	Format string
+	// Fields are dotted field paths to write. Default is fields of the first page.
+	// csv and tsv fail when a later page has a field which is not in the first page, because the header is already written.
+	Fields []string
+	// NoHeader does not write header of csv and tsv
+	NoHeader bool
+	// Array is how to write array. join, json, first or index
+	Array string
+	// ArraySeparator is used when Array is join
+	ArraySeparator string
+	// Query is a query clause to filter documents. Default is match_all
+	Query string
+}
+
+// flatten flattens _source to dotted field paths. e.g. {"user": {"name": "a"}} => {"user.name": "a"}
+func flatten(source map[string]interface{}, arrayMode string, separator string) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	err := flattenValue(result, "", source, arrayMode, separator)
+	return result, fail.Wrap(err)
+}
+
+func flattenValue(result map[string]interface{}, path string, value interface{}, arrayMode string, separator string) error {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if err := flattenValue(result, join(key), child, arrayMode, separator); err != nil {
+				return fail.Wrap(err)
+			}
+		}
+	case []interface{}:
+		switch arrayMode {
+		case ArrayFirst:
+			if len(v) > 0 {
+				return fail.Wrap(flattenValue(result, path, v[0], arrayMode, separator))
+			}
+		case ArrayIndex:
+			for n, child := range v {
+				if err := flattenValue(result, join(strconv.Itoa(n)), child, arrayMode, separator); err != nil {
+					return fail.Wrap(err)
+				}
+			}
+		case ArrayJSON:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+			result[path] = string(b)
+		case ArrayJoin, "":
+			elements := make([]string, len(v))
+			for n, child := range v {
+				s, err := cellString(child)
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				elements[n] = s
+			}
+			result[path] = strings.Join(elements, separator)
+		default:
+			return fail.New(fmt.Sprintf("Unknown array mode: %s", arrayMode))
+		}
+	default:
+		result[path] = v
+	}
+	return nil
+}
+
+// cellString returns string for csv. Objects are written as JSON.
+func cellString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		b, err := json.Marshal(v)
+		return string(b), fail.Wrap(err)
+	}
+}
+
+// hitFields returns sorted fields of flattened hits. _id is the first.
+func hitFields(records []map[string]interface{}) []string {
+	set := map[string]bool{}
+	for _, record := range records {
+		for field := range record {
+			if field != idField {
+				set[field] = true
+			}
+		}
+	}
+	fields := make([]string, 0, len(set))
+	for field := range set {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return append([]string{idField}, fields...)
+}
+
+// recordWriter writes flattened documents. Fields are decided by the first write when fields are empty.
+type recordWriter struct {
+	w      *bufio.Writer
+	csv    *csv.Writer
+	fields []string
+	// inferred is fields of the first page. It is nil when fields are given
+	inferred  map[string]bool
+	noHeader  bool
+	array     string
+	separator string
+	started   bool
+}
+
+func newRecordWriter(w io.Writer, opt ExportOpt) (*recordWriter, error) {
+	if opt.Format == "" {
+		opt.Format = FormatCSV
+	}
+	if opt.ArraySeparator == "" {
+		opt.ArraySeparator = "|"
+	}
+
+	bw := bufio.NewWriter(w)
+	rw := &recordWriter{
+		w:         bw,
+		fields:    opt.Fields,
+		noHeader:  opt.NoHeader,
+		array:     opt.Array,
+		separator: opt.ArraySeparator,
+	}
+	switch opt.Format {
+	case FormatCSV:
+		rw.csv = csv.NewWriter(bw)
+	case FormatTSV:
+		rw.csv = csv.NewWriter(bw)
+		rw.csv.Comma = '\t'
+	case FormatJSONL:
+	default:
+		return nil, fail.New(fmt.Sprintf("Unknown format: %s", opt.Format))
+	}
+	return rw, nil
+}
+
+func (rw *recordWriter) write(hits []es.SearchHit) error {
+	records := make([]map[string]interface{}, len(hits))
+	for n, hit := range hits {
+		record, err := flatten(hit.Source, rw.array, rw.separator)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		record[idField] = hit.ID
+		records[n] = record
+	}
+
+	if !rw.started && rw.csv != nil && len(rw.fields) == 0 {
+		rw.fields = hitFields(records)
+		rw.inferred = map[string]bool{}
+		for _, field := range rw.fields {
+			rw.inferred[field] = true
+		}
+	}
+	if rw.inferred != nil {
+		// Fields which appear after the first page can not be written, because the header is already written
+		for _, record := range records {
+			for field := range record {
+				if !rw.inferred[field] {
+					return fail.New(fmt.Sprintf("Field %s is not found in the first page. Specify fields to write", field))
+				}
+			}
+		}
+	}
+	if err := rw.start(); err != nil {
+		return fail.Wrap(err)
+	}
+
+	for _, record := range records {
+		var err error
+		if rw.csv != nil {
+			err = rw.writeCSV(record)
+		} else {
+			err = rw.writeJSONL(record)
+		}
+		if err != nil {
+			return fail.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func (rw *recordWriter) start() error {
+	if rw.started {
+		return nil
+	}
+	rw.started = true
+	if rw.csv != nil && !rw.noHeader && len(rw.fields) > 0 {
+		return fail.Wrap(rw.csv.Write(rw.fields))
+	}
+	return nil
+}
+
+func (rw *recordWriter) writeCSV(record map[string]interface{}) error {
+	row := make([]string, len(rw.fields))
+	for n, field := range rw.fields {
+		s, err := cellString(record[field])
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		row[n] = s
+	}
+	return fail.Wrap(rw.csv.Write(row))
+}
+
+func (rw *recordWriter) writeJSONL(record map[string]interface{}) error {
+	selected := record
+	if len(rw.fields) > 0 {
+		selected = make(map[string]interface{}, len(rw.fields))
+		for _, field := range rw.fields {
+			if value, ok := record[field]; ok {
+				selected[field] = value
+			}
+		}
+	}
+	b, err := json.Marshal(selected)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	_, err = rw.w.Write(append(b, '\n'))
+	return fail.Wrap(err)
+}
+
+func (rw *recordWriter) flush() error {
+	// Write header even if there is no document
+	if err := rw.start(); err != nil {
+		return fail.Wrap(err)
+	}
+	if rw.csv != nil {
+		rw.csv.Flush()
+		if err := rw.csv.Error(); err != nil {
+			return fail.Wrap(err)
+		}
+	}
+	return fail.Wrap(rw.w.Flush())
+}
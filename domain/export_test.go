@@ -0,0 +1,169 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rerost/es-cli/infra/es"
+)
+
+func TestFlatten(t *testing.T) {
+	t.Parallel()
+
+	source := map[string]interface{}{
+		"name": "alice",
+		"user": map[string]interface{}{"age": json.Number("20"), "address": map[string]interface{}{"city": "tokyo"}},
+		"tags": []interface{}{"a", json.Number("1"), map[string]interface{}{"k": "v"}},
+	}
+
+	type InOutPairs struct {
+		name      string
+		arrayMode string
+		want      map[string]interface{}
+		hasError  bool
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name:      "Join",
+			arrayMode: ArrayJoin,
+			want:      map[string]interface{}{"name": "alice", "user.age": json.Number("20"), "user.address.city": "tokyo", "tags": `a|1|{"k":"v"}`},
+		},
+		{
+			name:      "Default is join",
+			arrayMode: "",
+			want:      map[string]interface{}{"name": "alice", "user.age": json.Number("20"), "user.address.city": "tokyo", "tags": `a|1|{"k":"v"}`},
+		},
+		{
+			name:      "JSON",
+			arrayMode: ArrayJSON,
+			want:      map[string]interface{}{"name": "alice", "user.age": json.Number("20"), "user.address.city": "tokyo", "tags": `["a",1,{"k":"v"}]`},
+		},
+		{
+			name:      "First",
+			arrayMode: ArrayFirst,
+			want:      map[string]interface{}{"name": "alice", "user.age": json.Number("20"), "user.address.city": "tokyo", "tags": "a"},
+		},
+		{
+			name:      "Index",
+			arrayMode: ArrayIndex,
+			want:      map[string]interface{}{"name": "alice", "user.age": json.Number("20"), "user.address.city": "tokyo", "tags.0": "a", "tags.1": json.Number("1"), "tags.2.k": "v"},
+		},
+		{
+			name:      "Unknown",
+			arrayMode: "last",
+			hasError:  true,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := flatten(source, inOut.arrayMode, "|")
+			if (err != nil) != inOut.hasError {
+				t.Fatalf("hasError want %v, got %v", inOut.hasError, err)
+			}
+			if diff := cmp.Diff(inOut.want, got); !inOut.hasError && diff != "" {
+				t.Errorf("Not match fields, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestCellString(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name string
+		in   interface{}
+		want string
+	}
+	inOutPairs := []InOutPairs{
+		{name: "nil", in: nil, want: ""},
+		{name: "string", in: "a,b", want: "a,b"},
+		{name: "float", in: 1e21, want: "1000000000000000000000"},
+		{name: "number", in: json.Number("9007199254740993"), want: "9007199254740993"},
+		{name: "bool", in: false, want: "false"},
+		{name: "object", in: map[string]interface{}{"k": "v"}, want: `{"k":"v"}`},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := cellString(inOut.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != inOut.want {
+				t.Errorf("want %s, got %s", inOut.want, got)
+			}
+		})
+	}
+}
+
+func TestRecordWriter(t *testing.T) {
+	t.Parallel()
+
+	first := []es.SearchHit{{ID: "1", Source: map[string]interface{}{"name": "alice", "user": map[string]interface{}{"age": 20.0}}}}
+	second := []es.SearchHit{{ID: "2", Source: map[string]interface{}{"name": "bob", "email": "bob@example.com"}}}
+
+	type InOutPairs struct {
+		name     string
+		opt      ExportOpt
+		want     string
+		hasError bool
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name: "Header is fields of the first page",
+			opt:  ExportOpt{Format: FormatCSV},
+			want: "_id,name,user.age\n1,alice,20\n",
+			// email of the second page is not in the header
+			hasError: true,
+		},
+		{
+			name: "Fields",
+			opt:  ExportOpt{Format: FormatTSV, Fields: []string{"_id", "email", "user.age"}},
+			want: "_id\temail\tuser.age\n1\t\t20\n2\tbob@example.com\t\n",
+		},
+		{
+			name: "No header",
+			opt:  ExportOpt{Format: FormatCSV, Fields: []string{"name"}, NoHeader: true},
+			want: "alice\nbob\n",
+		},
+		{
+			name: "JSON Lines writes all fields",
+			opt:  ExportOpt{Format: FormatJSONL},
+			want: "{\"_id\":\"1\",\"name\":\"alice\",\"user.age\":20}\n{\"_id\":\"2\",\"email\":\"bob@example.com\",\"name\":\"bob\"}\n",
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			buf := &bytes.Buffer{}
+			w, err := newRecordWriter(buf, inOut.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = w.write(first)
+			if err == nil {
+				err = w.write(second)
+			}
+			if (err != nil) != inOut.hasError {
+				t.Fatalf("hasError want %v, got %v", inOut.hasError, err)
+			}
+			if err := w.flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != inOut.want {
+				t.Errorf("Not match output, want %q, got %q", inOut.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/rerost/es-cli/config"
+	"github.com/rerost/es-cli/infra/es"
+)
+
+// fakeResponse is a response of fakeServer. status is 200 when it is 0.
+type fakeResponse struct {
+	status int
+	body   string
+}
+
+// respond returns 200 responses of bodies.
+func respond(bodies ...string) []fakeResponse {
+	responses := make([]fakeResponse, len(bodies))
+	for n, body := range bodies {
+		responses[n] = fakeResponse{body: body}
+	}
+	return responses
+}
+
+type fakeRequest struct {
+	method string
+	path   string
+	query  string
+	body   string
+}
+
+// fakeServer responds by responses of "METHOD /path". Responses of a key are returned in order, and the last one is repeated.
+// Unknown requests are 404. Requests except the version request are recorded.
+type fakeServer struct {
+	mu        sync.Mutex
+	root      string
+	responses map[string][]fakeResponse
+	requests  []fakeRequest
+}
+
+func newFakeServer(t *testing.T, version string, responses map[string][]fakeResponse) (*fakeServer, es.BaseClient) {
+	return newFakeServerWithConfig(t, config.Config{Type: "_doc"}, fmt.Sprintf(`{"version": {"number": %q}}`, version), responses)
+}
+
+// newFakeServerWithConfig returns the server which responds root to the version request. Host of cfg is overwritten.
+func newFakeServerWithConfig(t *testing.T, cfg config.Config, root string, responses map[string][]fakeResponse) (*fakeServer, es.BaseClient) {
+	s := &fakeServer{root: root, responses: responses}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	cfg.Host = ts.URL
+	baseClient, _ := es.NewBaseClient(cfg, ts.Client())
+	return s, baseClient
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		fmt.Fprintln(w, s.root)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, fakeRequest{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery, body: string(body)})
+
+	key := r.Method + " " + r.URL.Path
+	responses, ok := s.responses[key]
+	if !ok || len(responses) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"error": "not found: %s"}`, key)
+		return
+	}
+	response := responses[0]
+	if len(responses) > 1 {
+		s.responses[key] = responses[1:]
+	}
+	if response.status != 0 {
+		w.WriteHeader(response.status)
+	}
+	fmt.Fprintln(w, response.body)
+}
+
+// calls returns "METHOD /path" of recorded requests.
+func (s *fakeServer) calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls := make([]string, len(s.requests))
+	for n, request := range s.requests {
+		calls[n] = request.method + " " + request.path
+	}
+	return calls
+}
+
+// bodies returns bodies of recorded requests of "METHOD /path".
+func (s *fakeServer) bodies(key string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bodies := []string{}
+	for _, request := range s.requests {
+		if request.method+" "+request.path == key {
+			bodies = append(bodies, request.body)
+		}
+	}
+	return bodies
+}
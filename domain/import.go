@@ -0,0 +1,275 @@
+package domain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/srvc/fail"
+)
+
+const (
+	FormatJSON = "json"
+
+	// inferSampleSize is number of documents to infer mapping
+	inferSampleSize = 100
+)
+
+type ImportOpt struct {
+	// Format is csv, json or jsonl. json accepts both of an array and JSON Lines
+	Format string
+	// IDField is the field used as _id. _id is generated by Elasticsearch when empty
+	IDField string
+	// Columns are types of csv columns. string, int, float, bool or json. Default is string
+	Columns map[string]string
+	// Detail creates the index before importing
+	Detail io.Reader
+	// InferMapping creates the index with mapping inferred from documents
+	InferMapping bool
+	// DeadLetter receives metadata + document pairs which are rejected by Elasticsearch.
+	DeadLetter io.Writer
+	Bulk       BulkOpt
+}
+
+// FormatOfFile returns the import format by the extension of the file. Unknown extensions are json, which also accepts JSON Lines.
+func FormatOfFile(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return FormatCSV
+	case ".tsv":
+		return FormatTSV
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	default:
+		return FormatJSON
+	}
+}
+
+// documentSource reads documents one by one. It returns io.EOF at the end.
+type documentSource interface {
+	next() (map[string]interface{}, error)
+}
+
+func newDocumentSource(fp io.Reader, opt ImportOpt) (documentSource, error) {
+	switch opt.Format {
+	case FormatCSV:
+		return newCSVSource(fp, ',', opt.Columns)
+	case FormatTSV:
+		return newCSVSource(fp, '\t', opt.Columns)
+	case FormatJSON, FormatJSONL, "":
+		return newJSONSource(fp)
+	default:
+		return nil, fail.New(fmt.Sprintf("Unknown format: %s", opt.Format))
+	}
+}
+
+type csvSource struct {
+	reader  *csv.Reader
+	header  []string
+	columns map[string]string
+}
+
+func newCSVSource(fp io.Reader, comma rune, columns map[string]string) (*csvSource, error) {
+	reader := csv.NewReader(fp)
+	reader.Comma = comma
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	for column, typ := range columns {
+		switch typ {
+		case "string", "int", "float", "bool", "json":
+		default:
+			return nil, fail.New(fmt.Sprintf("Unknown type of column %s: %s", column, typ))
+		}
+	}
+
+	return &csvSource{
+		reader:  reader,
+		header:  append([]string{}, header...),
+		columns: columns,
+	}, nil
+}
+
+func (s *csvSource) next() (map[string]interface{}, error) {
+	record, err := s.reader.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	doc := map[string]interface{}{}
+	for n, cell := range record {
+		if n >= len(s.header) || cell == "" {
+			continue
+		}
+		column := s.header[n]
+		value, err := s.typed(column, cell)
+		if err != nil {
+			return nil, fail.Wrap(err)
+		}
+		// Dotted column is nested. e.g. user.name => {"user": {"name": ...}}
+		setPath(doc, strings.Split(column, "."), value)
+	}
+	return doc, nil
+}
+
+func (s *csvSource) typed(column string, cell string) (interface{}, error) {
+	switch s.columns[column] {
+	case "int":
+		v, err := strconv.ParseInt(cell, 10, 64)
+		return v, fail.Wrap(err, fail.WithParam("column", column))
+	case "float":
+		v, err := strconv.ParseFloat(cell, 64)
+		return v, fail.Wrap(err, fail.WithParam("column", column))
+	case "bool":
+		v, err := strconv.ParseBool(cell)
+		return v, fail.Wrap(err, fail.WithParam("column", column))
+	case "json":
+		var v interface{}
+		decoder := json.NewDecoder(strings.NewReader(cell))
+		decoder.UseNumber()
+		err := decoder.Decode(&v)
+		return v, fail.Wrap(err, fail.WithParam("column", column))
+	default:
+		return cell, nil
+	}
+}
+
+func setPath(doc map[string]interface{}, path []string, value interface{}) {
+	if len(path) == 1 {
+		doc[path[0]] = value
+		return
+	}
+	child, ok := doc[path[0]].(map[string]interface{})
+	if !ok {
+		child = map[string]interface{}{}
+		doc[path[0]] = child
+	}
+	setPath(child, path[1:], value)
+}
+
+// jsonSource reads a JSON array of documents or JSON Lines.
+type jsonSource struct {
+	decoder *json.Decoder
+	array   bool
+}
+
+func newJSONSource(fp io.Reader) (*jsonSource, error) {
+	r := bufio.NewReader(fp)
+	array := false
+	for {
+		b, err := r.Peek(1)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fail.Wrap(err)
+		}
+		if len(bytes.TrimSpace(b)) != 0 {
+			array = b[0] == '['
+			break
+		}
+		r.ReadByte()
+	}
+
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if array {
+		// Skip [
+		if _, err := decoder.Token(); err != nil {
+			return nil, fail.Wrap(err)
+		}
+	}
+	return &jsonSource{decoder: decoder, array: array}, nil
+}
+
+func (s *jsonSource) next() (map[string]interface{}, error) {
+	if s.array && !s.decoder.More() {
+		return nil, io.EOF
+	}
+
+	doc := map[string]interface{}{}
+	err := s.decoder.Decode(&doc)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	return doc, fail.Wrap(err)
+}
+
+// inferMapping infers properties from documents like dynamic mapping of Elasticsearch.
+func inferMapping(docs []map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for _, doc := range docs {
+		inferProperties(properties, doc)
+	}
+	return map[string]interface{}{"properties": properties}
+}
+
+func inferProperties(properties map[string]interface{}, doc map[string]interface{}) {
+	for field, value := range doc {
+		if _, ok := properties[field]; ok {
+			// The first type wins
+			if child, ok := value.(map[string]interface{}); ok {
+				if p, ok := properties[field].(map[string]interface{})["properties"].(map[string]interface{}); ok {
+					inferProperties(p, child)
+				}
+			}
+			continue
+		}
+		if property := inferProperty(value); property != nil {
+			properties[field] = property
+		}
+	}
+}
+
+func inferProperty(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties := map[string]interface{}{}
+		inferProperties(properties, v)
+		return map[string]interface{}{"properties": properties}
+	case []interface{}:
+		// Array is the same type as elements
+		for _, element := range v {
+			if property := inferProperty(element); property != nil {
+				return property
+			}
+		}
+		return nil
+	case bool:
+		return map[string]interface{}{"type": "boolean"}
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return map[string]interface{}{"type": "long"}
+		}
+		return map[string]interface{}{"type": "double"}
+	case int64:
+		return map[string]interface{}{"type": "long"}
+	case float64:
+		return map[string]interface{}{"type": "double"}
+	case string:
+		if _, err := time.Parse(time.RFC3339, v); err == nil {
+			return map[string]interface{}{"type": "date"}
+		}
+		return map[string]interface{}{
+			"type": "text",
+			"fields": map[string]interface{}{
+				"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256},
+			},
+		}
+	default:
+		return nil
+	}
+}
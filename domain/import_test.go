@@ -0,0 +1,222 @@
+package domain
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func readDocuments(source documentSource) ([]map[string]interface{}, error) {
+	docs := []map[string]interface{}{}
+	for {
+		doc, err := source.next()
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+}
+
+func TestFormatOfFile(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		in   string
+		want string
+	}
+	inOutPairs := []InOutPairs{
+		{in: "orders.csv", want: FormatCSV},
+		{in: "orders.TSV", want: FormatTSV},
+		{in: "orders.jsonl", want: FormatJSONL},
+		{in: "orders.ndjson", want: FormatJSONL},
+		{in: "orders.json", want: FormatJSON},
+		{in: "orders.txt", want: FormatJSON},
+		{in: "orders", want: FormatJSON},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.in, func(t *testing.T) {
+			t.Parallel()
+			if got := FormatOfFile(inOut.in); got != inOut.want {
+				t.Errorf("want %s, got %s", inOut.want, got)
+			}
+		})
+	}
+}
+
+func TestCSVSource(t *testing.T) {
+	t.Parallel()
+
+	columns := map[string]string{"age": "int", "price": "float", "active": "bool", "tags": "json"}
+
+	type InOutPairs struct {
+		name     string
+		in       string
+		comma    rune
+		columns  map[string]string
+		want     []map[string]interface{}
+		hasError bool
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name:    "Typed columns",
+			in:      "name,age,price,active,tags\nalice,20,1.5,true,\"[\"\"a\"\",1]\"\n",
+			comma:   ',',
+			columns: columns,
+			want: []map[string]interface{}{
+				{"name": "alice", "age": int64(20), "price": 1.5, "active": true, "tags": []interface{}{"a", json.Number("1")}},
+			},
+		},
+		{
+			name:  "Dotted columns are nested and empty cells are omitted",
+			in:    "user.name\tuser.id\tnote\nalice\t1\t\n",
+			comma: '\t',
+			want: []map[string]interface{}{
+				{"user": map[string]interface{}{"name": "alice", "id": "1"}},
+			},
+		},
+		{
+			name:     "Invalid number",
+			in:       "age\ntwenty\n",
+			comma:    ',',
+			columns:  columns,
+			hasError: true,
+		},
+		{
+			name:     "Unknown type",
+			in:       "age\n20\n",
+			comma:    ',',
+			columns:  map[string]string{"age": "integer"},
+			hasError: true,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			var got []map[string]interface{}
+			source, err := newCSVSource(strings.NewReader(inOut.in), inOut.comma, inOut.columns)
+			if err == nil {
+				got, err = readDocuments(source)
+			}
+			if (err != nil) != inOut.hasError {
+				t.Fatalf("hasError want %v, got %v", inOut.hasError, err)
+			}
+			if diff := cmp.Diff(inOut.want, got); !inOut.hasError && diff != "" {
+				t.Errorf("Not match documents, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestJSONSource(t *testing.T) {
+	t.Parallel()
+
+	want := []map[string]interface{}{
+		{"n": json.Number("9007199254740993")},
+		{"user": map[string]interface{}{"name": "alice"}},
+	}
+
+	type InOutPairs struct {
+		name     string
+		in       string
+		want     []map[string]interface{}
+		hasError bool
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name: "Array",
+			in:   "\n  [{\"n\": 9007199254740993},\n {\"user\": {\"name\": \"alice\"}}]\n",
+			want: want,
+		},
+		{
+			name: "JSON Lines",
+			in:   "{\"n\": 9007199254740993}\n{\"user\": {\"name\": \"alice\"}}\n",
+			want: want,
+		},
+		{
+			name: "Empty",
+			in:   "\n",
+			want: []map[string]interface{}{},
+		},
+		{
+			name: "Empty array",
+			in:   "[]",
+			want: []map[string]interface{}{},
+		},
+		{
+			name:     "Not object",
+			in:       "[1, 2]",
+			hasError: true,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			var got []map[string]interface{}
+			source, err := newJSONSource(strings.NewReader(inOut.in))
+			if err == nil {
+				got, err = readDocuments(source)
+			}
+			if (err != nil) != inOut.hasError {
+				t.Fatalf("hasError want %v, got %v", inOut.hasError, err)
+			}
+			if diff := cmp.Diff(inOut.want, got); !inOut.hasError && diff != "" {
+				t.Errorf("Not match documents, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestInferMapping(t *testing.T) {
+	t.Parallel()
+
+	text := map[string]interface{}{
+		"type":   "text",
+		"fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256}},
+	}
+	docs := []map[string]interface{}{
+		{
+			"id":         json.Number("1"),
+			"price":      json.Number("1.5"),
+			"active":     true,
+			"name":       "alice",
+			"created_at": "2020-01-01T00:00:00Z",
+			"tags":       []interface{}{"a"},
+			"empty":      []interface{}{},
+			"user":       map[string]interface{}{"age": json.Number("20")},
+			"note":       nil,
+		},
+		{
+			// The first type wins, and properties of objects are merged
+			"id":   "x",
+			"user": map[string]interface{}{"email": "alice@example.com"},
+		},
+	}
+	want := map[string]interface{}{"properties": map[string]interface{}{
+		"id":         map[string]interface{}{"type": "long"},
+		"price":      map[string]interface{}{"type": "double"},
+		"active":     map[string]interface{}{"type": "boolean"},
+		"name":       text,
+		"created_at": map[string]interface{}{"type": "date"},
+		"tags":       text,
+		"user": map[string]interface{}{"properties": map[string]interface{}{
+			"age":   map[string]interface{}{"type": "long"},
+			"email": text,
+		}},
+	}}
+
+	if diff := cmp.Diff(want, inferMapping(docs)); diff != "" {
+		t.Errorf("Not match mapping, diff(-want, +got) %s", diff)
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rerost/es-cli/infra/es"
@@ -28,8 +30,49 @@ type Index interface {
 	Delete(ctx context.Context, indexName string) error
 	Copy(ctx context.Context, srcIndex, destIndex string) error
 	Count(ctx context.Context, indexName string) (int64, error)
-	Dump(ctx context.Context, indexName string, fp io.Writer) error
-	Restore(ctx context.Context, fp io.Reader) error
+	Dump(ctx context.Context, indexName string, fp io.Writer, opt DumpOpt) error
+	Restore(ctx context.Context, fp io.Reader, opt RestoreOpt) error
+	Export(ctx context.Context, indexName string, fp io.Writer, opt ExportOpt) error
+	Import(ctx context.Context, indexName string, fp io.Reader, opt ImportOpt) error
+	Search(ctx context.Context, indexName string, fp io.Writer, opt SearchOpt) error
+}
+
+type DumpOpt struct {
+	// Slices is number of parallel readers. Each slice is written to a separate chunk file.
+	Slices int
+	// Dir is the directory to write documents
+	Dir string
+	// KeepAlive is keep alive of point in time or scroll. e.g. 5m
+	KeepAlive string
+	// Checkpoint is the file to persist the cursor. Default is <dir>/<index>_dump.checkpoint.json
+	Checkpoint string
+	// Resume continues dump from Checkpoint
+	Resume bool
+	// Query is a query clause to filter documents. Default is match_all
+	Query string
+	// Includes and Excludes are _source filtering
+	Includes []string
+	Excludes []string
+	// MaxDocs limits number of documents. 0 is unlimited
+	MaxDocs int64
+	// Sample is ratio of documents to dump. e.g. 0.1 dumps about 10% of documents
+	Sample float64
+	// SampleSeed decides documents of Sample. The same seed samples the same documents while the index is not changed
+	SampleSeed int64
+	// Mask is a masking spec. Masked fields are reported to stderr
+	Mask io.Reader
+}
+
+type RestoreOpt struct {
+	// Detail is a dumped detail. When set, the index is created from it before loading documents.
+	Detail io.Reader
+	// TargetIndex overwrites _index of dumped documents. "{index}" is replaced with the dumped index name. e.g. {index}_restored
+	TargetIndex string
+	// DeadLetter receives metadata + document pairs which are rejected by Elasticsearch.
+	DeadLetter io.Writer
+	Bulk       BulkOpt
+	// Mask is a masking spec. Masked fields are reported to stderr
+	Mask io.Reader
 }
 
 func NewIndex(esBaseClient es.BaseClient) Index {
@@ -104,22 +147,9 @@ func (i indexImpl) Copy(ctx context.Context, srcIndex, destIndex string) error {
 	}
 
 	fmt.Fprintf(os.Stdout, "TaskID is %s\n", task.ID)
-	zap.L().Debug("Start task", zap.String("task_id: ", task.ID))
-
-	for try := 1; ; try++ {
-		// Back off
-		wait := time.Second * time.Duration(try*try)
-		time.Sleep(wait)
-		zap.L().Debug("Waiting for complete copy", zap.Duration("waited(s)", wait))
-		task, err := i.esBaseClient.GetTask(ctx, task.ID)
-
-		if err != nil {
-			return fail.Wrap(err)
-		}
-
-		if task.Complete == true {
-			break
-		}
+	_, err = waitTask(ctx, i.esBaseClient, task.ID)
+	if err != nil {
+		return fail.Wrap(err)
 	}
 	srcIndexCount, err := i.esBaseClient.CountIndex(ctx, srcIndex)
 	if err != nil {
@@ -149,7 +179,7 @@ func (i indexImpl) Count(ctx context.Context, indexName string) (int64, error) {
 	return c.Num, nil
 }
 
-func (i indexImpl) Dump(ctx context.Context, indexName string, detailFile io.Writer) error {
+func (i indexImpl) Dump(ctx context.Context, indexName string, detailFile io.Writer, opt DumpOpt) error {
 	detail, err := i.esBaseClient.DetailIndex(ctx, indexName)
 	if err != nil {
 		return fail.Wrap(err)
@@ -160,49 +190,272 @@ func (i indexImpl) Dump(ctx context.Context, indexName string, detailFile io.Wri
 		return fail.Wrap(err)
 	}
 
-	dumpFile, err := os.Create(fmt.Sprintf("./%s_dump.ndjson", indexName))
+	if opt.Slices < 1 {
+		opt.Slices = 1
+	}
+	if opt.Dir == "" {
+		opt.Dir = "."
+	}
+	if opt.Checkpoint == "" {
+		opt.Checkpoint = checkpointFileName(opt.Dir, indexName)
+	}
+
+	rOpt, options, err := dumpReaderOpt(opt)
 	if err != nil {
 		return fail.Wrap(err)
 	}
-	defer dumpFile.Close()
+	limiter := &docLimiter{max: opt.MaxDocs}
 
-	lastID := ""
-	for {
-		query := fmt.Sprintf(`{"query": {"match_all": {}}, "size": %d, "sort": [{"_id": "desc"}]}`, BATCH_SIZE)
-		if lastID != "" {
-			zap.L().Info("Copying search after", zap.String("ID", lastID))
-			query = fmt.Sprintf(`{"query": {"match_all": {}}, "size": %d, "sort": [{"_id": "desc"}], "search_after": ["%s"]}`, BATCH_SIZE, lastID)
+	var m *masker
+	if opt.Mask != nil {
+		m, err = newMasker(opt.Mask)
+		if err != nil {
+			return fail.Wrap(err)
 		}
-		searchResult, err := i.esBaseClient.SearchIndex(ctx, indexName, query)
+	}
 
+	var readers []documentReader
+	var cp *checkpointer
+	closeReaders := func(ctx context.Context) error { return nil }
+	if opt.Resume {
+		info, err := i.esBaseClient.ServerInfo(ctx)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		// Scroll does not write the checkpoint
+		if !info.PointInTime(false) {
+			return fail.New(fmt.Sprintf("Resume requires point in time of Elasticsearch 7.12 or later, and OpenSearch is not supported, but the cluster is %s", info))
+		}
+		checkpoint, err := loadCheckpoint(opt.Checkpoint)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		if checkpoint.Index != indexName {
+			return fail.New(fmt.Sprintf("Checkpoint is for index %s", checkpoint.Index))
+		}
+		if !checkpoint.Options.equal(options) {
+			return fail.New(fmt.Sprintf("Options are different from the checkpoint: %s", jsonString(checkpoint.Options)))
+		}
+		err = checkPointInTime(ctx, i.esBaseClient, checkpoint.PitID, rOpt.keepAlive)
 		if err != nil {
 			return fail.Wrap(err)
 		}
+		opt.Slices = len(checkpoint.Slices)
+		for _, slice := range checkpoint.Slices {
+			limiter.taken += slice.Docs
+		}
+		readers = resumeReaders(i.esBaseClient, checkpoint, rOpt)
+		cp = &checkpointer{fileName: opt.Checkpoint, checkpoint: checkpoint}
+		closeReaders = func(ctx context.Context) error {
+			return fail.Wrap(i.esBaseClient.ClosePointInTime(ctx, cp.pitID()))
+		}
+	} else {
+		readers, closeReaders, err = openReaders(ctx, i.esBaseClient, indexName, rOpt, opt.Slices)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		// Only search_after with point in time can be resumed. Scroll can not go back to the last page.
+		if r, ok := readers[0].(*pitReader); ok {
+			cp = &checkpointer{
+				fileName:   opt.Checkpoint,
+				checkpoint: dumpCheckpoint{Index: indexName, PitID: r.pitID, Options: options, Slices: make([]sliceCheckpoint, opt.Slices)},
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resumes := make([]sliceCheckpoint, len(readers))
+	if opt.Resume {
+		copy(resumes, cp.checkpoint.Slices)
+	}
 
-		for _, hit := range searchResult.Hits.Hits {
-			metaData := fmt.Sprintf(`{ "index" : { "_index": "%s", "_type": "%s", "_id": "%s" }}`, hit.Index, hit.Type, hit.ID)
-			queryBytes, err := json.Marshal(hit.Source)
+	var firstErr error
+	var once sync.Once
+	wg := sync.WaitGroup{}
+	for n, reader := range readers {
+		wg.Add(1)
+		go func(n int, reader documentReader, resume sliceCheckpoint) {
+			defer wg.Done()
+			err := dumpSlice(ctx, reader, dumpFileName(opt.Dir, indexName, n, opt.Slices), n, resume, cp, limiter, m)
 			if err != nil {
-				return fail.Wrap(err)
+				// Other slices are canceled by the first error
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
 			}
-			_, err = dumpFile.Write([]byte(metaData + "\n" + string(queryBytes) + "\n"))
+		}(n, reader, resumes[n])
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		// Keep point in time alive so that dump can be resumed
+		if cp != nil {
+			zap.L().Info("Dump can be resumed with --resume", zap.String("checkpoint", opt.Checkpoint))
+		} else if err := closeReaders(context.Background()); err != nil {
+			zap.L().Warn("Failed to close readers", zap.Error(err))
+		}
+		return fail.Wrap(firstErr)
+	}
+
+	if err := closeReaders(ctx); err != nil {
+		zap.L().Warn("Failed to close readers", zap.Error(err))
+	}
+	if m != nil {
+		fmt.Fprintln(os.Stderr, m.report())
+	}
+	return fail.Wrap(cp.remove())
+}
+
+// dumpFileName returns the file name of a chunk. It is <index>_dump.ndjson when not sliced.
+func dumpFileName(dir string, indexName string, slice int, slices int) string {
+	if slices <= 1 {
+		return filepath.Join(dir, fmt.Sprintf("%s_dump.ndjson", indexName))
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s_dump_%d.ndjson", indexName, slice))
+}
+
+// dumpReaderOpt returns options of readers, and options recorded in the checkpoint.
+func dumpReaderOpt(opt DumpOpt) (readerOpt, dumpOptions, error) {
+	rOpt := readerOpt{keepAlive: opt.KeepAlive, includes: opt.Includes, excludes: opt.Excludes}
+	if opt.Query != "" {
+		var err error
+		rOpt.query, err = parseQuery([]byte(opt.Query))
+		if err != nil {
+			return readerOpt{}, dumpOptions{}, fail.Wrap(err)
+		}
+	}
+	options := dumpOptions{Query: rOpt.query, Includes: opt.Includes, Excludes: opt.Excludes, Sample: opt.Sample, SampleSeed: opt.SampleSeed, MaxDocs: opt.MaxDocs}
+	if opt.Sample > 0 && opt.Sample < 1 {
+		rOpt.query = sampleQuery(rOpt.query, opt.Sample, opt.SampleSeed)
+	}
+	if opt.MaxDocs > 0 && opt.MaxDocs < BATCH_SIZE {
+		rOpt.size = int(opt.MaxDocs)
+	}
+	return rOpt, options, nil
+}
+
+// docLimiter limits number of documents across slices. max 0 is unlimited.
+type docLimiter struct {
+	mu    sync.Mutex
+	max   int64
+	taken int64
+}
+
+// take returns number of documents allowed to write out of n.
+func (l *docLimiter) take(n int) int {
+	if l.max <= 0 {
+		return n
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rest := l.max - l.taken
+	if rest < int64(n) {
+		n = int(rest)
+	}
+	if n < 0 {
+		n = 0
+	}
+	l.taken += int64(n)
+	return n
+}
+
+func dumpSlice(ctx context.Context, reader documentReader, fileName string, slice int, resume sliceCheckpoint, cp *checkpointer, limiter *docLimiter, m *masker) error {
+	if resume.Done {
+		return nil
+	}
+
+	var dumpFile *os.File
+	var err error
+	if resume.Offset > 0 {
+		dumpFile, err = os.OpenFile(fileName, os.O_RDWR, 0644)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		// Drop documents written after the checkpoint
+		err = dumpFile.Truncate(resume.Offset)
+		if err != nil {
+			dumpFile.Close()
+			return fail.Wrap(err)
+		}
+		_, err = dumpFile.Seek(resume.Offset, io.SeekStart)
+	} else {
+		dumpFile, err = os.Create(fileName)
+	}
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	defer dumpFile.Close()
+
+	w := bufio.NewWriter(dumpFile)
+	state := resume
+	for {
+		hits, err := reader.next(ctx)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		if len(hits) == 0 {
+			break
+		}
+		hits = hits[:limiter.take(len(hits))]
+		if len(hits) == 0 {
+			break
+		}
+
+		for _, hit := range hits {
+			m.mask(hit.Source)
+			err := writeBulkPair(w, hit)
 			if err != nil {
 				return fail.Wrap(err)
 			}
 		}
+		err = w.Flush()
+		if err != nil {
+			return fail.Wrap(err)
+		}
 
-		hitsSize := len(searchResult.Hits.Hits)
-		if hitsSize == 0 {
-			break
+		state.Docs += int64(len(hits))
+		state.Offset, err = dumpFile.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		if r, ok := reader.(*pitReader); ok {
+			state.PitID = r.pitID
+			state.SearchAfter = r.searchAfter
 		}
+		err = cp.save(slice, state)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		zap.L().Info("Dumped", zap.String("file", fileName), zap.Int64("documents", state.Docs))
+	}
 
-		lastID = searchResult.Hits.Hits[hitsSize-1].ID
+	state.Done = true
+	return fail.Wrap(cp.save(slice, state))
+}
+
+func writeBulkPair(w io.Writer, hit es.SearchHit) error {
+	meta := map[string]string{"_index": hit.Index, "_id": hit.ID}
+	if hit.Type != "" {
+		meta["_type"] = hit.Type
+	}
+	metaBytes, err := json.Marshal(map[string]interface{}{"index": meta})
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	docBytes, err := json.Marshal(hit.Source)
+	if err != nil {
+		return fail.Wrap(err)
 	}
 
-	return nil
+	_, err = w.Write([]byte(string(metaBytes) + "\n" + string(docBytes) + "\n"))
+	return fail.Wrap(err)
 }
 
-func (i indexImpl) Restore(ctx context.Context, fp io.Reader) error {
+func (i indexImpl) Restore(ctx context.Context, fp io.Reader, opt RestoreOpt) error {
 	scanner := bufio.NewScanner(fp)
 
 	scanner.Split(bufio.ScanLines)
@@ -211,29 +464,326 @@ func (i indexImpl) Restore(ctx context.Context, fp io.Reader) error {
 		scanner.Buffer(buf, maxBufSize)
 	}
 
-	// twice, because metadata + document pair
-	buf := make([]string, BATCH_SIZE*2, BATCH_SIZE*2)
-	iter := 0
-	batchTime := 1
-	for scanner.Scan() {
-		buf[iter] = scanner.Text()
+	info, err := i.esBaseClient.ServerInfo(ctx)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	rewriter := bulkMetaRewriter{targetIndex: opt.TargetIndex}
+	// Elasticsearch 6.x requires _type, 7.x deprecates and 8.x rejects it
+	if info.Typed() {
+		rewriter.typeName = i.esBaseClient.TypeName()
+	}
+
+	var m *masker
+	if opt.Mask != nil {
+		m, err = newMasker(opt.Mask)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+	}
 
-		if iter == len(buf)-1 {
-			zap.L().Debug("Copied", zap.Int("size", len(buf)/2*batchTime))
-			err := i.esBaseClient.BulkIndex(ctx, strings.Join(buf, "\n")+"\n")
+	pipeline := newBulkPipeline(ctx, i.esBaseClient, opt.Bulk, opt.DeadLetter)
+	created := opt.Detail == nil
+	readErr := func() error {
+		for scanner.Scan() {
+			meta, err := rewriter.rewrite(scanner.Text())
 			if err != nil {
 				return fail.Wrap(err)
 			}
 
-			buf = make([]string, len(buf), len(buf))
-			iter = 0
-			batchTime++
-		} else {
-			iter++
+			// metadata + document pair
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return fail.Wrap(err)
+				}
+				return fail.New(fmt.Sprintf("Not found document for metadata: %s", meta))
+			}
+			doc := scanner.Text()
+			if m != nil {
+				doc, err = m.maskDocument(doc)
+				if err != nil {
+					return fail.Wrap(err)
+				}
+			}
+
+			if !created {
+				indexName, err := bulkMetaIndex(meta)
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				err = i.createFromDetail(ctx, indexName, opt.Detail)
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				created = true
+			}
+
+			err = pipeline.add(ctx, bulkPair{meta: meta, doc: doc})
+			if err != nil {
+				return fail.Wrap(err)
+			}
 		}
+		return fail.Wrap(scanner.Err())
+	}()
+
+	summary, stats, err := pipeline.close(ctx)
+	if readErr != nil {
+		return fail.Wrap(readErr)
 	}
-	if err := scanner.Err(); err != nil {
+	if err != nil {
 		return fail.Wrap(err)
 	}
+
+	if m != nil {
+		fmt.Fprintln(os.Stderr, m.report())
+	}
+	return fail.Wrap(reportBulk(summary, stats, opt.DeadLetter))
+}
+
+// reportBulk prints the result of bulk pipeline. It fails when documents are rejected and they are not written to dead letter.
+func reportBulk(summary es.BulkResult, stats bulkStats, deadLetter io.Writer) error {
+	fmt.Fprintln(os.Stdout, summary.String())
+	fmt.Fprintln(os.Stdout, stats.String())
+	if failed := summary.FailedCount() + summary.Retryable; failed > 0 && deadLetter == nil {
+		return fail.New(fmt.Sprintf("Failed to write %d documents", failed))
+	}
 	return nil
 }
+
+func (i indexImpl) Export(ctx context.Context, indexName string, fp io.Writer, opt ExportOpt) error {
+	rOpt := readerOpt{}
+	if opt.Query != "" {
+		var err error
+		rOpt.query, err = parseQuery([]byte(opt.Query))
+		if err != nil {
+			return fail.Wrap(err)
+		}
+	}
+
+	w, err := newRecordWriter(fp, opt)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	readers, closeReaders, err := openReaders(ctx, i.esBaseClient, indexName, rOpt, 1)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	defer func() {
+		if err := closeReaders(ctx); err != nil {
+			zap.L().Warn("Failed to close readers", zap.Error(err))
+		}
+	}()
+
+	exported := 0
+	for {
+		hits, err := readers[0].next(ctx)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		if len(hits) == 0 {
+			break
+		}
+
+		err = w.write(hits)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		exported += len(hits)
+		zap.L().Debug("Exported", zap.Int("documents", exported))
+	}
+
+	return fail.Wrap(w.flush())
+}
+
+func (i indexImpl) Import(ctx context.Context, indexName string, fp io.Reader, opt ImportOpt) error {
+	source, err := newDocumentSource(fp, opt)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	info, err := i.esBaseClient.ServerInfo(ctx)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	// Documents read to infer mapping
+	buffered := []map[string]interface{}{}
+	switch {
+	case opt.Detail != nil:
+		err = i.createFromDetail(ctx, indexName, opt.Detail)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+	case opt.InferMapping:
+		for len(buffered) < inferSampleSize {
+			doc, err := source.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fail.Wrap(err)
+			}
+			buffered = append(buffered, doc)
+		}
+
+		// Typeless mapping is wrapped with the type name for 6.x by CreateIndex
+		detail, err := json.Marshal(map[string]interface{}{"mappings": inferMapping(buffered)})
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		zap.L().Info("Create index with inferred mapping", zap.String("index", indexName))
+		zap.L().Debug("Inferred mapping", zap.String("detail", string(detail)))
+		err = i.esBaseClient.CreateIndex(ctx, indexName, string(detail))
+		if err != nil {
+			return fail.Wrap(err)
+		}
+	}
+
+	pipeline := newBulkPipeline(ctx, i.esBaseClient, opt.Bulk, opt.DeadLetter)
+	readErr := func() error {
+		for {
+			var doc map[string]interface{}
+			if len(buffered) > 0 {
+				doc, buffered = buffered[0], buffered[1:]
+			} else {
+				doc, err = source.next()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return fail.Wrap(err)
+				}
+			}
+
+			meta := map[string]interface{}{"_index": indexName}
+			if info.Typed() {
+				meta["_type"] = i.esBaseClient.TypeName()
+			}
+			if opt.IDField != "" {
+				id, err := cellString(doc[opt.IDField])
+				if err != nil {
+					return fail.Wrap(err)
+				}
+				if id == "" {
+					return fail.New(fmt.Sprintf("Not found id field %s in document", opt.IDField))
+				}
+				meta["_id"] = id
+			}
+
+			metaBytes, err := json.Marshal(map[string]interface{}{"index": meta})
+			if err != nil {
+				return fail.Wrap(err)
+			}
+			docBytes, err := json.Marshal(doc)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+
+			err = pipeline.add(ctx, bulkPair{meta: string(metaBytes), doc: string(docBytes)})
+			if err != nil {
+				return fail.Wrap(err)
+			}
+		}
+	}()
+
+	summary, stats, err := pipeline.close(ctx)
+	if readErr != nil {
+		return fail.Wrap(readErr)
+	}
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	return fail.Wrap(reportBulk(summary, stats, opt.DeadLetter))
+}
+
+func (i indexImpl) createFromDetail(ctx context.Context, indexName string, fp io.Reader) error {
+	detail := es.IndexDetail{}
+	err := json.NewDecoder(fp).Decode(&detail)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	detail, err = creatableDetail(detail)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	zap.L().Info("Create index from detail", zap.String("index", indexName))
+	err = i.esBaseClient.CreateIndex(ctx, indexName, detail.String())
+	return fail.Wrap(err)
+}
+
+// bulkMetaRewriter rewrites bulk metadata of dumped documents for the target cluster.
+type bulkMetaRewriter struct {
+	targetIndex string
+	// typeName is set to _type which is missing. _type is dropped when it is empty
+	typeName string
+}
+
+func (r bulkMetaRewriter) rewrite(line string) (string, error) {
+	meta := map[string]map[string]interface{}{}
+	err := json.Unmarshal([]byte(line), &meta)
+	if err != nil {
+		return "", fail.Wrap(err)
+	}
+
+	for _, m := range meta {
+		if r.targetIndex != "" {
+			indexName, _ := m["_index"].(string)
+			m["_index"] = strings.Replace(r.targetIndex, "{index}", indexName, -1)
+		}
+		if r.typeName == "" {
+			delete(m, "_type")
+		} else if _, ok := m["_type"]; !ok {
+			m["_type"] = r.typeName
+		}
+	}
+
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return "", fail.Wrap(err)
+	}
+	return string(b), nil
+}
+
+// bulkMetaIndex returns _index of bulk metadata line. e.g. { "index" : { "_index": "test", "_id": "1" }}
+func bulkMetaIndex(line string) (string, error) {
+	meta := map[string]map[string]interface{}{}
+	err := json.Unmarshal([]byte(line), &meta)
+	if err != nil {
+		return "", fail.Wrap(err)
+	}
+
+	for _, m := range meta {
+		if indexName, ok := m["_index"].(string); ok && indexName != "" {
+			return indexName, nil
+		}
+	}
+	return "", fail.New(fmt.Sprintf("Not found _index in bulk metadata: %s", line))
+}
+
+// waitTask waits until the task is completed with back off.
+func waitTask(ctx context.Context, esBaseClient es.BaseClient, taskID string) (es.Task, error) {
+	zap.L().Debug("Start task", zap.String("task_id: ", taskID))
+
+	for try := 1; ; try++ {
+		// Back off
+		wait := time.Second * time.Duration(try*try)
+		select {
+		case <-ctx.Done():
+			return es.Task{}, fail.Wrap(ctx.Err())
+		case <-time.After(wait):
+		}
+		zap.L().Debug("Waiting for complete task", zap.Duration("waited(s)", wait))
+		task, err := esBaseClient.GetTask(ctx, taskID)
+		if err != nil {
+			return task, fail.Wrap(err)
+		}
+
+		if task.Complete {
+			return task, nil
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rerost/es-cli/config"
+)
+
+func TestDumpReaderOpt(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name    string
+		opt     DumpOpt
+		body    string
+		options string
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name:    "Default",
+			opt:     DumpOpt{},
+			body:    `{"query":{"match_all":{}},"size":1000}`,
+			options: `{"query":null,"includes":null,"excludes":null,"sample":0,"sample_seed":0,"max_docs":0}`,
+		},
+		{
+			name:    "Search body and source filtering",
+			opt:     DumpOpt{Query: `{"query": {"term": {"n": 1}}}`, Includes: []string{"id", "user.*"}, Excludes: []string{"user.email"}},
+			body:    `{"_source":{"excludes":["user.email"],"includes":["id","user.*"]},"query":{"term":{"n":1}},"size":1000}`,
+			options: `{"query":{"term":{"n":1}},"includes":["id","user.*"],"excludes":["user.email"],"sample":0,"sample_seed":0,"max_docs":0}`,
+		},
+		{
+			name:    "Max docs smaller than a page",
+			opt:     DumpOpt{Query: `{"term": {"n": 1}}`, MaxDocs: 10},
+			body:    `{"query":{"term":{"n":1}},"size":10}`,
+			options: `{"query":{"term":{"n":1}},"includes":null,"excludes":null,"sample":0,"sample_seed":0,"max_docs":10}`,
+		},
+		{
+			name:    "Max docs larger than a page",
+			opt:     DumpOpt{MaxDocs: 5000},
+			body:    `{"query":{"match_all":{}},"size":1000}`,
+			options: `{"query":null,"includes":null,"excludes":null,"sample":0,"sample_seed":0,"max_docs":5000}`,
+		},
+		{
+			name:    "Sample",
+			opt:     DumpOpt{Query: `{"term": {"n": 1}}`, Sample: 0.1, SampleSeed: 42},
+			body:    `{"query":{"function_score":{"boost_mode":"replace","min_score":0.9,"query":{"term":{"n":1}},"random_score":{"field":"_seq_no","seed":42}}},"size":1000}`,
+			options: `{"query":{"term":{"n":1}},"includes":null,"excludes":null,"sample":0.1,"sample_seed":42,"max_docs":0}`,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			rOpt, options, err := dumpReaderOpt(inOut.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := json.Marshal(rOpt.withDefault().body())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != inOut.body {
+				t.Errorf("Not match body, want %s, got %s", inOut.body, body)
+			}
+			// Options recorded in the checkpoint do not have the sample query, so that they are compared with the user's options
+			if got := jsonString(options); got != inOut.options {
+				t.Errorf("Not match options, want %s, got %s", inOut.options, got)
+			}
+		})
+	}
+}
+
+func TestDocLimiter(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name  string
+		max   int64
+		taken int64
+		takes []int
+		want  []int
+	}
+	inOutPairs := []InOutPairs{
+		{name: "Unlimited", max: 0, takes: []int{1000, 1000}, want: []int{1000, 1000}},
+		{name: "Limited", max: 1500, takes: []int{1000, 1000, 1000}, want: []int{1000, 500, 0}},
+		{name: "Resumed", max: 1500, taken: 1200, takes: []int{1000}, want: []int{300}},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			limiter := &docLimiter{max: inOut.max, taken: inOut.taken}
+			got := []int{}
+			for _, n := range inOut.takes {
+				got = append(got, limiter.take(n))
+			}
+			if diff := cmp.Diff(inOut.want, got); diff != "" {
+				t.Errorf("Not match taken, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+
+	// Slices share the limit
+	limiter := &docLimiter{max: 1000}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	total := 0
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				taken := limiter.take(30)
+				mu.Lock()
+				total += taken
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if total != 1000 {
+		t.Errorf("Slices must take 1000 documents, got %d", total)
+	}
+}
+
+func TestRestoreWithDetail(t *testing.T) {
+	t.Parallel()
+
+	// The detail which is written by dump
+	detail := `{"settings": {"index": {"number_of_shards": "1", "uuid": "u", "creation_date": "1", "provided_name": "orders", "version": {"created": "1"}}}, "mappings": {"properties": {"n": {"type": "long"}}}, "aliases": {}}`
+	dump := strings.Join([]string{
+		`{"index":{"_index":"orders","_id":"1"}}`, `{"n":1}`,
+		`{"index":{"_index":"orders","_id":"2"}}`, `{"n":2}`,
+	}, "\n")
+
+	server, baseClient := newFakeServer(t, "7.17.0", map[string][]fakeResponse{
+		"PUT /orders_restored": respond(`{"acknowledged": true}`),
+		"POST /_bulk":          respond(`{"items": [{"index": {"status": 201}}, {"index": {"status": 201}}]}`),
+	})
+	indexDomain := indexImpl{esBaseClient: baseClient}
+
+	err := indexDomain.Restore(context.Background(), strings.NewReader(dump), RestoreOpt{Detail: strings.NewReader(detail), TargetIndex: "{index}_restored"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The index is created by the target name before documents, without settings managed by the server
+	if diff := cmp.Diff([]string{"PUT /orders_restored", "POST /_bulk"}, server.calls()); diff != "" {
+		t.Errorf("Not match requests, diff(-want, +got) %s", diff)
+	}
+	created := `{"settings":{"index":{"number_of_shards":"1"}},"aliases":{},"mappings":{"properties":{"n":{"type":"long"}}}}`
+	if diff := cmp.Diff([]string{created}, server.bodies("PUT /orders_restored")); diff != "" {
+		t.Errorf("Not match detail, diff(-want, +got) %s", diff)
+	}
+}
+
+func TestBulkMetaRewriter(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name     string
+		rewriter bulkMetaRewriter
+		in       string
+		want     string
+		hasError bool
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name:     "Target index",
+			rewriter: bulkMetaRewriter{targetIndex: "{index}_restored"},
+			in:       `{"index":{"_index":"orders","_id":"1"}}`,
+			want:     `{"index":{"_id":"1","_index":"orders_restored"}}`,
+		},
+		{
+			name:     "Target index without template",
+			rewriter: bulkMetaRewriter{targetIndex: "restored"},
+			in:       `{"create":{"_index":"orders","_id":"1"}}`,
+			want:     `{"create":{"_id":"1","_index":"restored"}}`,
+		},
+		{
+			name:     "Drop type",
+			rewriter: bulkMetaRewriter{},
+			in:       `{"index":{"_index":"orders","_type":"_doc","_id":"1"}}`,
+			want:     `{"index":{"_id":"1","_index":"orders"}}`,
+		},
+		{
+			name:     "Set missing type",
+			rewriter: bulkMetaRewriter{typeName: "doc"},
+			in:       `{"index":{"_index":"orders","_id":"1"}}`,
+			want:     `{"index":{"_id":"1","_index":"orders","_type":"doc"}}`,
+		},
+		{
+			name:     "Keep dumped type",
+			rewriter: bulkMetaRewriter{typeName: "doc"},
+			in:       `{"index":{"_index":"orders","_type":"order","_id":"1"}}`,
+			want:     `{"index":{"_id":"1","_index":"orders","_type":"order"}}`,
+		},
+		{
+			name:     "Not metadata",
+			rewriter: bulkMetaRewriter{},
+			in:       `{"n":1}`,
+			hasError: true,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := inOut.rewriter.rewrite(inOut.in)
+			if (err != nil) != inOut.hasError {
+				t.Fatalf("hasError want %v, got %v", inOut.hasError, err)
+			}
+			if got != inOut.want {
+				t.Errorf("want %s, got %s", inOut.want, got)
+			}
+		})
+	}
+}
+
+func TestDumpResumeOpenSearch(t *testing.T) {
+	t.Parallel()
+
+	root := `{"version": {"number": "2.11.0", "distribution": "opensearch"}}`
+	server, baseClient := newFakeServerWithConfig(t, config.Config{Type: "_doc"}, root, map[string][]fakeResponse{
+		"GET /orders": respond(`{"orders": {"aliases": {}, "mappings": {}, "settings": {}}}`),
+	})
+	indexDomain := indexImpl{esBaseClient: baseClient}
+
+	err := indexDomain.Dump(context.Background(), "orders", new(bytes.Buffer), DumpOpt{Dir: t.TempDir(), Resume: true})
+	if err == nil || !strings.Contains(err.Error(), "OpenSearch is not supported") {
+		t.Fatalf("Resume must fail on OpenSearch, got %v", err)
+	}
+	if diff := cmp.Diff([]string{"GET /orders"}, server.calls()); diff != "" {
+		t.Errorf("Not match requests, diff(-want, +got) %s", diff)
+	}
+}
@@ -0,0 +1,222 @@
+package domain_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rerost/es-cli/config"
+	"github.com/rerost/es-cli/domain"
+	"github.com/rerost/es-cli/infra/es"
+)
+
+// fakeDumpServer serves documents by point in time. It fails the search request of failAt times.
+type fakeDumpServer struct {
+	mu       sync.Mutex
+	docs     int
+	size     int
+	failAt   int
+	searched int
+	// pitIDs are point in time ids of search requests
+	pitIDs []string
+	// expired makes searches fail as point in time has expired
+	expired bool
+}
+
+func (s *fakeDumpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/":
+		fmt.Fprintln(w, `{"version": {"number": "7.17.0"}}`)
+	case r.URL.Path == "/test":
+		fmt.Fprintln(w, `{"test": {"aliases": {}, "mappings": {}, "settings": {}}}`)
+	case r.URL.Path == "/test/_pit":
+		fmt.Fprintln(w, `{"id": "pit"}`)
+	case r.URL.Path == "/_pit":
+		fmt.Fprintln(w, `{"succeeded": true}`)
+	case r.URL.Path == "/_search":
+		query := struct {
+			SearchAfter []float64 `json:"search_after"`
+			Pit         struct {
+				ID string `json:"id"`
+			} `json:"pit"`
+			Slice struct {
+				ID  int `json:"id"`
+				Max int `json:"max"`
+			} `json:"slice"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&query)
+
+		s.mu.Lock()
+		s.searched++
+		searched := s.searched
+		s.pitIDs = append(s.pitIDs, query.Pit.ID)
+		expired := s.expired
+		s.mu.Unlock()
+		if expired {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, `{"error": {"type": "search_context_missing_exception"}}`)
+			return
+		}
+		if searched == s.failAt {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, `{"error": "node is down"}`)
+			return
+		}
+
+		from := 0
+		if len(query.SearchAfter) > 0 {
+			from = int(query.SearchAfter[0]) + 1
+		}
+		hits := []string{}
+		for n := from; len(hits) < s.size && n < s.docs; n++ {
+			if query.Slice.Max > 1 && n%query.Slice.Max != query.Slice.ID {
+				continue
+			}
+			hits = append(hits, fmt.Sprintf(`{"_index": "test", "_id": "%d", "_source": {"n": %d}, "sort": [%d]}`, n, n, n))
+		}
+		fmt.Fprintf(w, `{"pit_id": "pit", "hits": {"total": {"value": %d}, "hits": [%s]}}`, s.docs, strings.Join(hits, ","))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, `{"error": "not found"}`)
+	}
+}
+
+func TestDumpResumeExpired(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	server := &fakeDumpServer{docs: 5, size: 2, failAt: 2}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	baseClient, _ := es.NewBaseClient(config.Config{Host: ts.URL, Type: "_doc"}, ts.Client())
+	index := domain.NewIndex(baseClient)
+
+	err := index.Dump(ctx, "test", new(bytes.Buffer), domain.DumpOpt{Dir: dir})
+	if err == nil {
+		t.Fatal("Dump must fail")
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "test_dump.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	server.expired = true
+	searched := server.searched
+	server.mu.Unlock()
+
+	err = index.Dump(ctx, "test", new(bytes.Buffer), domain.DumpOpt{Dir: dir, Resume: true})
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("Resume must fail because point in time has expired, got %v", err)
+	}
+	// Nothing is read nor written before the check
+	server.mu.Lock()
+	checked := server.searched - searched
+	server.mu.Unlock()
+	if checked != 1 {
+		t.Errorf("Only the point in time must be checked, got %d searches", checked)
+	}
+	after, err := ioutil.ReadFile(filepath.Join(dir, "test_dump.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(b) {
+		t.Errorf("Dumped file must not be changed, got %s", after)
+	}
+}
+
+func TestDumpResume(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name   string
+		slices int
+		failAt int
+	}
+	inOutPairs := []InOutPairs{
+		{name: "Single slice", slices: 1, failAt: 2},
+		// One of slices fails at the first search, so it does not have a checkpoint
+		{name: "Multiple slices", slices: 2, failAt: 1},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			server := &fakeDumpServer{docs: 5, size: 2, failAt: inOut.failAt}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			cfg := config.Config{
+				Host: ts.URL,
+				Type: "_doc",
+			}
+			baseClient, _ := es.NewBaseClient(cfg, ts.Client())
+			index := domain.NewIndex(baseClient)
+
+			checkpointFile := filepath.Join(dir, "test_dump.checkpoint.json")
+			err := index.Dump(ctx, "test", new(bytes.Buffer), domain.DumpOpt{Dir: dir, Slices: inOut.slices})
+			if err == nil {
+				t.Fatal("Dump must fail")
+			}
+			if _, err := os.Stat(checkpointFile); err != nil {
+				t.Fatalf("Checkpoint must be kept: %v", err)
+			}
+
+			err = index.Dump(ctx, "test", new(bytes.Buffer), domain.DumpOpt{Dir: dir, Resume: true, Query: `{"term": {"n": 1}}`})
+			if err == nil {
+				t.Fatal("Resume with different options must fail")
+			}
+
+			err = index.Dump(ctx, "test", new(bytes.Buffer), domain.DumpOpt{Dir: dir, Resume: true})
+			if err != nil {
+				t.Fatalf("Failed to resume: %v", err)
+			}
+			if _, err := os.Stat(checkpointFile); !os.IsNotExist(err) {
+				t.Errorf("Checkpoint must be removed: %v", err)
+			}
+			for _, pitID := range server.pitIDs {
+				if pitID != "pit" {
+					t.Errorf("Search without point in time: %q", pitID)
+				}
+			}
+
+			got := []string{}
+			for slice := 0; slice < inOut.slices; slice++ {
+				fileName := filepath.Join(dir, "test_dump.ndjson")
+				if inOut.slices > 1 {
+					fileName = filepath.Join(dir, fmt.Sprintf("test_dump_%d.ndjson", slice))
+				}
+				b, err := ioutil.ReadFile(fileName)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, strings.Split(strings.TrimSpace(string(b)), "\n")...)
+			}
+			want := []string{}
+			for slice := 0; slice < inOut.slices; slice++ {
+				for n := slice; n < server.docs; n += inOut.slices {
+					want = append(want, fmt.Sprintf(`{"index":{"_id":"%d","_index":"test"}}`, n), fmt.Sprintf(`{"n":%d}`, n))
+				}
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("Not mutch dump, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
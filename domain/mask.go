@@ -0,0 +1,257 @@
+package domain
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/srvc/fail"
+)
+
+const (
+	// MaskRedact replaces value with [REDACTED]
+	MaskRedact = "redact"
+	// MaskHash replaces value with HMAC-SHA256 of the value. The same value is the same hash, so relationships across documents survive
+	MaskHash = "hash"
+	// MaskFake replaces value with a fake value derived from the hash. e.g. email => user_1a2b3c4d@example.com
+	MaskFake = "fake"
+	// MaskKeep does not mask. It is used for exceptions of wildcard
+	MaskKeep = "keep"
+
+	redacted = "[REDACTED]"
+)
+
+// maskSpec is the masking spec file.
+// e.g. {"salt": "secret", "fields": {"user.email": "hash", "user.*": "fake", "user.id": "keep"}}
+type maskSpec struct {
+	Salt string `json:"salt"`
+	// Fields are dotted field paths or wildcard patterns to actions
+	Fields map[string]string `json:"fields"`
+}
+
+// masker masks fields of documents. It is safe for concurrent use.
+type masker struct {
+	salt     []byte
+	exact    map[string]string
+	patterns []string
+	actions  map[string]string
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newMasker(fp io.Reader) (*masker, error) {
+	spec := maskSpec{}
+	err := json.NewDecoder(fp).Decode(&spec)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	m := &masker{
+		salt:    []byte(spec.Salt),
+		exact:   map[string]string{},
+		actions: map[string]string{},
+		counts:  map[string]int{},
+	}
+	for field, action := range spec.Fields {
+		switch action {
+		case MaskRedact, MaskHash, MaskFake, MaskKeep:
+		default:
+			return nil, fail.New(fmt.Sprintf("Unknown mask action of %s: %s", field, action))
+		}
+		if strings.ContainsAny(field, "*?[") {
+			m.patterns = append(m.patterns, field)
+			m.actions[field] = action
+		} else {
+			m.exact[field] = action
+		}
+	}
+	sort.Strings(m.patterns)
+	return m, nil
+}
+
+// action returns action of the field. Exact path wins over patterns.
+func (m *masker) action(field string) string {
+	if action, ok := m.exact[field]; ok {
+		return action
+	}
+	for _, pattern := range m.patterns {
+		if ok, _ := path.Match(pattern, field); ok {
+			return m.actions[pattern]
+		}
+	}
+	return ""
+}
+
+// mask masks source in place. It does nothing when m is nil.
+func (m *masker) mask(source map[string]interface{}) {
+	if m == nil {
+		return
+	}
+	m.maskObject("", source)
+}
+
+// maskDocument masks a JSON document. Numbers are decoded as json.Number, so that unmasked integers larger than 2^53 are kept as they are.
+func (m *masker) maskDocument(doc string) (string, error) {
+	decoder := json.NewDecoder(strings.NewReader(doc))
+	decoder.UseNumber()
+	source := map[string]interface{}{}
+	err := decoder.Decode(&source)
+	if err != nil {
+		return "", fail.Wrap(err)
+	}
+	m.mask(source)
+	b, err := json.Marshal(source)
+	return string(b), fail.Wrap(err)
+}
+
+func (m *masker) maskObject(prefix string, object map[string]interface{}) {
+	for key, value := range object {
+		field := key
+		if prefix != "" {
+			field = prefix + "." + key
+		}
+		object[key] = m.maskValue(field, value)
+	}
+}
+
+func (m *masker) maskValue(field string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		// Each element of array has the same field path
+		for n, element := range v {
+			v[n] = m.maskValue(field, element)
+		}
+		return v
+	}
+
+	action := m.action(field)
+	if action == "" || action == MaskKeep {
+		if object, ok := value.(map[string]interface{}); ok {
+			m.maskObject(field, object)
+		}
+		return value
+	}
+	if value == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	m.counts[field]++
+	m.mu.Unlock()
+
+	switch action {
+	case MaskRedact:
+		return m.redact(value)
+	case MaskHash:
+		return m.hash(value)
+	default:
+		return m.fake(value)
+	}
+}
+
+func (m *masker) sum(value interface{}) []byte {
+	b, _ := json.Marshal(value)
+	mac := hmac.New(sha256.New, m.salt)
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+func (m *masker) redact(value interface{}) interface{} {
+	switch value.(type) {
+	case float64, json.Number:
+		return 0
+	case bool:
+		return false
+	case string:
+		return redacted
+	default:
+		return nil
+	}
+}
+
+func (m *masker) hash(value interface{}) interface{} {
+	sum := m.sum(value)
+	switch value.(type) {
+	case float64, json.Number:
+		// Keep number type for mapping. 2^53 is max safe integer of JSON
+		return binary.BigEndian.Uint64(sum[:8]) % (1 << 53)
+	case bool:
+		return sum[0]%2 == 0
+	default:
+		return hex.EncodeToString(sum)
+	}
+}
+
+var fakeNames = []string{
+	"Alex", "Blake", "Casey", "Drew", "Emery", "Finley", "Gray", "Harper",
+	"Indigo", "Jordan", "Kai", "Logan", "Morgan", "Noel", "Parker", "Quinn",
+	"Reese", "Sage", "Taylor", "Val",
+}
+
+func (m *masker) fake(value interface{}) interface{} {
+	sum := m.sum(value)
+	s, ok := value.(string)
+	if !ok {
+		return m.hash(value)
+	}
+
+	short := hex.EncodeToString(sum[:4])
+	switch {
+	case strings.Contains(s, "@"):
+		return fmt.Sprintf("user_%s@example.com", short)
+	case isPhoneNumber(s):
+		// Keep format. e.g. +81-90-1234-5678
+		digits := []byte(s)
+		for n, c := range digits {
+			if c >= '0' && c <= '9' {
+				digits[n] = '0' + sum[n%len(sum)]%10
+			}
+		}
+		return string(digits)
+	default:
+		return fmt.Sprintf("%s %s", fakeNames[int(sum[0])%len(fakeNames)], short)
+	}
+}
+
+func isPhoneNumber(s string) bool {
+	digits := 0
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case strings.ContainsRune("+-() .", c):
+		default:
+			return false
+		}
+	}
+	return digits >= 7
+}
+
+// report returns number of masked values by field.
+func (m *masker) report() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fields := make([]string, 0, len(m.counts))
+	total := 0
+	for field, count := range m.counts {
+		fields = append(fields, field)
+		total += count
+	}
+	sort.Strings(fields)
+
+	result := []string{fmt.Sprintf("Masked: %d", total)}
+	for _, field := range fields {
+		result = append(result, fmt.Sprintf("  %s: %d", field, m.counts[field]))
+	}
+	return strings.Join(result, "\n")
+}
@@ -0,0 +1,91 @@
+package domain
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMaskDocument(t *testing.T) {
+	t.Parallel()
+
+	spec := `{"salt": "secret", "fields": {"user.*": "fake", "user.id": "keep", "user.email": "hash", "phone": "redact", "tags": "hash"}}`
+	m, err := newMasker(strings.NewReader(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := `{"id": 9007199254740993, "price": 1.50, "phone": "+81-90-1234-5678", "tags": ["a", "a"], "user": {"id": 9007199254740993, "name": "Alice", "email": "alice@example.com", "age": 20}}`
+	masked, err := m.maskDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]interface{}{}
+	decoder := json.NewDecoder(strings.NewReader(masked))
+	decoder.UseNumber()
+	if err := decoder.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	user := got["user"].(map[string]interface{})
+
+	// Numbers which are not masked are not rounded by float64
+	if diff := cmp.Diff(json.Number("9007199254740993"), got["id"]); diff != "" {
+		t.Errorf("Not match id, diff(-want, +got) %s", diff)
+	}
+	if diff := cmp.Diff(json.Number("1.50"), got["price"]); diff != "" {
+		t.Errorf("Not match price, diff(-want, +got) %s", diff)
+	}
+	// Exact path wins over the wildcard
+	if diff := cmp.Diff(json.Number("9007199254740993"), user["id"]); diff != "" {
+		t.Errorf("Not match user.id, diff(-want, +got) %s", diff)
+	}
+	if got["phone"] != redacted {
+		t.Errorf("phone must be redacted, got %v", got["phone"])
+	}
+	if user["name"] == "Alice" || user["email"] == "alice@example.com" {
+		t.Errorf("user must be masked, got %v", user)
+	}
+	if email, _ := user["email"].(string); len(email) != 64 {
+		t.Errorf("user.email must be hashed, got %v", user["email"])
+	}
+	if _, ok := user["age"].(json.Number); !ok {
+		t.Errorf("Masked number must be a number, got %v", user["age"])
+	}
+	// The same value is masked to the same value
+	if tags := got["tags"].([]interface{}); tags[0] != tags[1] || tags[0] == "a" {
+		t.Errorf("tags must be the same hash, got %v", tags)
+	}
+}
+
+func TestMaskHash(t *testing.T) {
+	t.Parallel()
+
+	newTestMasker := func(salt string) *masker {
+		m, err := newMasker(strings.NewReader(`{"salt": "` + salt + `", "fields": {"n": "hash"}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	a := newTestMasker("a")
+	b := newTestMasker("b")
+
+	// HMAC is deterministic for the same salt, and numbers decoded by dump and restore are the same hash
+	if a.hash(float64(12)) != a.hash(json.Number("12")) {
+		t.Error("Hash must not depend on how the number is decoded")
+	}
+	if a.hash("alice") != newTestMasker("a").hash("alice") {
+		t.Error("Hash must be deterministic")
+	}
+	if a.hash("alice") == b.hash("alice") {
+		t.Error("Hash must depend on the salt")
+	}
+	if a.hash("alice") == a.hash("bob") {
+		t.Error("Hash of different values must be different")
+	}
+	if n, ok := a.hash(float64(12)).(uint64); !ok || n >= 1<<53 {
+		t.Errorf("Hash of a number must be a safe integer, got %v", a.hash(float64(12)))
+	}
+}
@@ -0,0 +1,66 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/rerost/es-cli/infra/es"
+	"github.com/srvc/fail"
+)
+
+// MetadataIndex stores records of es-cli. e.g. indices which aliases pointed to
+const MetadataIndex = "es-cli-metadata"
+
+// metadataStore reads and writes documents of MetadataIndex. The index is created automatically on the first write.
+type metadataStore struct {
+	esBaseClient es.BaseClient
+}
+
+// get returns false when the document does not exist.
+func (s metadataStore) get(ctx context.Context, id string, v interface{}) (bool, error) {
+	document, err := s.esBaseClient.GetDocument(ctx, MetadataIndex, id)
+	if err != nil {
+		return false, fail.Wrap(err)
+	}
+	if !document.Found {
+		return false, nil
+	}
+	return true, fail.Wrap(json.Unmarshal(document.Source, v))
+}
+
+func (s metadataStore) put(ctx context.Context, id string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	return fail.Wrap(s.esBaseClient.PutDocument(ctx, MetadataIndex, id, string(body)))
+}
+
+// aliasHistory is indices which the alias pointed to. The last generation is the latest.
+type aliasHistory struct {
+	Alias       string            `json:"alias"`
+	Generations []aliasGeneration `json:"generations"`
+	// RolledBack are indices which the alias pointed to before rollback. They are deleted by the next update
+	RolledBack []string `json:"rolled_back,omitempty"`
+}
+
+type aliasGeneration struct {
+	Indices    []string  `json:"indices"`
+	ReplacedBy []string  `json:"replaced_by"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func aliasHistoryID(alias string) string {
+	return "alias:" + alias
+}
+
+func (s metadataStore) aliasHistory(ctx context.Context, alias string) (aliasHistory, error) {
+	history := aliasHistory{Alias: alias}
+	_, err := s.get(ctx, aliasHistoryID(alias), &history)
+	return history, fail.Wrap(err)
+}
+
+func (s metadataStore) putAliasHistory(ctx context.Context, history aliasHistory) error {
+	return fail.Wrap(s.put(ctx, aliasHistoryID(history.Alias), history))
+}
@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMetadataStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	server, baseClient := newFakeServer(t, "7.17.0", map[string][]fakeResponse{
+		"GET /es-cli-metadata/_doc/alias:orders": respond(`{"found": true, "_source": {"alias": "orders", "generations": [{"indices": ["orders_v1"], "replaced_by": ["orders_v2"]}]}}`),
+		"PUT /es-cli-metadata/_doc/alias:orders": respond(`{"result": "updated"}`),
+	})
+	store := metadataStore{esBaseClient: baseClient}
+
+	// Not found is not an error
+	found, err := store.get(ctx, "missing", &aliasHistory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("Missing document must not be found")
+	}
+
+	history, err := store.aliasHistory(ctx, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := aliasHistory{Alias: "orders", Generations: []aliasGeneration{{Indices: []string{"orders_v1"}, ReplacedBy: []string{"orders_v2"}}}}
+	if diff := cmp.Diff(want, history); diff != "" {
+		t.Errorf("Not match history, diff(-want, +got) %s", diff)
+	}
+
+	history.Generations = nil
+	history.RolledBack = []string{"orders_v2"}
+	err = store.putAliasHistory(ctx, history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{`{"alias":"orders","generations":null,"rolled_back":["orders_v2"]}`}, server.bodies("PUT /es-cli-metadata/_doc/alias:orders")); diff != "" {
+		t.Errorf("Not match saved history, diff(-want, +got) %s", diff)
+	}
+}
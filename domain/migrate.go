@@ -0,0 +1,400 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rerost/es-cli/infra/es"
+	"github.com/srvc/fail"
+	"go.uber.org/zap"
+)
+
+const (
+	StepCreateIndex  = "create_index"
+	StepUpdateDetail = "update_detail"
+	StepAliases      = "aliases"
+	StepReindex      = "reindex"
+	StepDeleteIndex  = "delete_index"
+
+	migrationsID = "migrations"
+)
+
+// migrationFileName is <version>_<name>.up.json or <version>_<name>.down.json
+var migrationFileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.json$`)
+
+// Migration applies migration files in a directory in order of version, and records applied versions in MetadataIndex.
+type Migration interface {
+	// Up applies all pending migrations
+	Up(ctx context.Context, dir string) error
+	// Down reverts the last count migrations by down files
+	Down(ctx context.Context, dir string, count int) error
+	Status(ctx context.Context, dir string) ([]MigrationStatus, error)
+}
+
+func NewMigration(esBaseClient es.BaseClient, detailDomain Detail) Migration {
+	return migrationImpl{
+		esBaseClient: esBaseClient,
+		detailDomain: detailDomain,
+	}
+}
+
+type migrationImpl struct {
+	esBaseClient es.BaseClient
+	detailDomain Detail
+}
+
+type MigrationStatus struct {
+	Version   string
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Modified is true when the up file is changed after applied
+	Modified bool
+	// Missing is true when the file of the applied migration is not found
+	Missing bool
+}
+
+func (s MigrationStatus) String() string {
+	state := "pending"
+	if s.Applied {
+		state = "applied at " + s.AppliedAt.Format(time.RFC3339)
+	}
+	switch {
+	case s.Missing:
+		state += " (file is missing)"
+	case s.Modified:
+		state += " (modified after applied)"
+	}
+	return fmt.Sprintf("%s_%s: %s", s.Version, s.Name, state)
+}
+
+// migrationSpec is the content of a migration file. e.g. {"steps": [{"type": "create_index", "index": "orders_v1", "detail_file": "orders.json"}]}
+type migrationSpec struct {
+	Steps []migrationStep `json:"steps"`
+}
+
+type migrationStep struct {
+	Type string `json:"type"`
+	// Index is an index of create_index and delete_index, or an alias or an index of update_detail
+	Index string `json:"index"`
+	// Detail or DetailFile is used by create_index and update_detail. DetailFile is relative to the migration file
+	Detail     json.RawMessage `json:"detail"`
+	DetailFile string          `json:"detail_file"`
+	// Actions are actions of _aliases. e.g. [{"add": {"index": "orders_v1", "alias": "orders"}}]
+	Actions []map[string]map[string]interface{} `json:"actions"`
+	// Body is a body of _reindex
+	Body json.RawMessage `json:"body"`
+
+	SafeMode       string `json:"safe_mode"`
+	TimestampField string `json:"timestamp_field"`
+	KeepOld        int    `json:"keep_old"`
+	NameTemplate   string `json:"name_template"`
+	// SHA is used for {sha} of NameTemplate. It is required by sha templates
+	SHA string `json:"sha"`
+}
+
+type migrationFile struct {
+	version string
+	// number is the version as a number. 0001 and 1 are the same version
+	number   int
+	name     string
+	upPath   string
+	downPath string
+}
+
+type migrationRecord struct {
+	Applied []appliedMigration `json:"applied"`
+}
+
+type appliedMigration struct {
+	Version   string    `json:"version"`
+	Name      string    `json:"name"`
+	Checksum  string    `json:"checksum"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+func loadMigrationFiles(dir string) ([]migrationFile, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	files := map[int]*migrationFile{}
+	for _, entry := range entries {
+		m := migrationFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || m == nil {
+			continue
+		}
+		version, name, direction := m[1], m[2], m[3]
+		number, err := strconv.Atoi(version)
+		if err != nil {
+			return nil, fail.Wrap(err, fail.WithParam("file", entry.Name()))
+		}
+		file, ok := files[number]
+		if !ok {
+			file = &migrationFile{version: version, number: number, name: name}
+			files[number] = file
+		}
+		if file.version != version || file.name != name {
+			return nil, fail.New(fmt.Sprintf("Version %d is duplicated: %s_%s, %s_%s", number, file.version, file.name, version, name))
+		}
+		path := filepath.Join(dir, entry.Name())
+		if direction == "up" {
+			file.upPath = path
+		} else {
+			file.downPath = path
+		}
+	}
+
+	result := make([]migrationFile, 0, len(files))
+	for _, file := range files {
+		if file.upPath == "" {
+			return nil, fail.New(fmt.Sprintf("Up file of version %s is not found", file.version))
+		}
+		result = append(result, *file)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].number < result[j].number
+	})
+	return result, nil
+}
+
+// versionNumber returns the version of an applied migration as a number. Versions are recorded from file names, so they are numbers.
+func versionNumber(version string) int {
+	number, _ := strconv.Atoi(version)
+	return number
+}
+
+func checksum(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fail.Wrap(err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (m migrationImpl) record(ctx context.Context) (migrationRecord, error) {
+	record := migrationRecord{}
+	_, err := metadataStore{esBaseClient: m.esBaseClient}.get(ctx, migrationsID, &record)
+	return record, fail.Wrap(err)
+}
+
+func (m migrationImpl) saveRecord(ctx context.Context, record migrationRecord) error {
+	return fail.Wrap(metadataStore{esBaseClient: m.esBaseClient}.put(ctx, migrationsID, record))
+}
+
+func (m migrationImpl) Status(ctx context.Context, dir string) ([]MigrationStatus, error) {
+	files, err := loadMigrationFiles(dir)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	record, err := m.record(ctx)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	applied := map[int]appliedMigration{}
+	for _, migration := range record.Applied {
+		applied[versionNumber(migration.Version)] = migration
+	}
+
+	statuses := []MigrationStatus{}
+	for _, file := range files {
+		status := MigrationStatus{Version: file.version, Name: file.name}
+		if migration, ok := applied[file.number]; ok {
+			sum, err := checksum(file.upPath)
+			if err != nil {
+				return nil, fail.Wrap(err)
+			}
+			status.Applied = true
+			status.AppliedAt = migration.AppliedAt
+			status.Modified = sum != migration.Checksum
+			delete(applied, file.number)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, migration := range record.Applied {
+		if _, ok := applied[versionNumber(migration.Version)]; ok {
+			statuses = append(statuses, MigrationStatus{Version: migration.Version, Name: migration.Name, Applied: true, AppliedAt: migration.AppliedAt, Missing: true})
+		}
+	}
+	return statuses, nil
+}
+
+func (m migrationImpl) Up(ctx context.Context, dir string) error {
+	files, err := loadMigrationFiles(dir)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	record, err := m.record(ctx)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	applied := map[int]bool{}
+	for _, migration := range record.Applied {
+		applied[versionNumber(migration.Version)] = true
+	}
+
+	for _, file := range files {
+		if applied[file.number] {
+			continue
+		}
+		zap.L().Info("Migrate up", zap.String("version", file.version), zap.String("name", file.name))
+		err = m.apply(ctx, file.upPath)
+		if err != nil {
+			return fail.Wrap(err, fail.WithParam("version", file.version))
+		}
+
+		sum, err := checksum(file.upPath)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		// Record each migration, so that applied migrations are not applied again after failure
+		record.Applied = append(record.Applied, appliedMigration{Version: file.version, Name: file.name, Checksum: sum, AppliedAt: time.Now()})
+		err = m.saveRecord(ctx, record)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func (m migrationImpl) Down(ctx context.Context, dir string, count int) error {
+	files, err := loadMigrationFiles(dir)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	record, err := m.record(ctx)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	downPaths := map[int]string{}
+	for _, file := range files {
+		downPaths[file.number] = file.downPath
+	}
+
+	for n := 0; n < count && len(record.Applied) > 0; n++ {
+		last := record.Applied[len(record.Applied)-1]
+		downPath := downPaths[versionNumber(last.Version)]
+		if downPath == "" {
+			return fail.New(fmt.Sprintf("Down file of version %s is not found", last.Version))
+		}
+
+		zap.L().Info("Migrate down", zap.String("version", last.Version), zap.String("name", last.Name))
+		err = m.apply(ctx, downPath)
+		if err != nil {
+			return fail.Wrap(err, fail.WithParam("version", last.Version))
+		}
+
+		record.Applied = record.Applied[:len(record.Applied)-1]
+		err = m.saveRecord(ctx, record)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func (m migrationImpl) apply(ctx context.Context, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	spec := migrationSpec{}
+	err = json.Unmarshal(b, &spec)
+	if err != nil {
+		return fail.Wrap(err, fail.WithParam("file", path))
+	}
+
+	// Steps are checked before any step is applied
+	for n, step := range spec.Steps {
+		if step.SHA == "" && (step.NameTemplate == NameSHA || strings.Contains(step.NameTemplate, "{sha}")) {
+			return fail.Wrap(fail.New("sha is required for the name template"), fail.WithParam("file", path), fail.WithParam("step", n+1))
+		}
+	}
+
+	for n, step := range spec.Steps {
+		zap.L().Info("Apply step", zap.Int("step", n+1), zap.String("type", step.Type))
+		err = m.applyStep(ctx, filepath.Dir(path), step)
+		if err != nil {
+			return fail.Wrap(err, fail.WithParam("step", n+1))
+		}
+	}
+	return nil
+}
+
+func (m migrationImpl) applyStep(ctx context.Context, dir string, step migrationStep) error {
+	switch step.Type {
+	case StepCreateIndex:
+		detail, err := step.detail(dir)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		return fail.Wrap(m.esBaseClient.CreateIndex(ctx, step.Index, string(detail)))
+	case StepUpdateDetail:
+		detail, err := step.detail(dir)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		opt := UpdateOpt{
+			SafeMode:       step.SafeMode,
+			TimestampField: step.TimestampField,
+			KeepOld:        step.KeepOld,
+			NameTemplate:   step.NameTemplate,
+			SHA:            step.SHA,
+		}
+		return fail.Wrap(m.detailDomain.Update(ctx, step.Index, bytes.NewReader(detail), opt))
+	case StepAliases:
+		actions := []es.AliasAction{}
+		for _, action := range step.Actions {
+			for typ, params := range action {
+				options := map[string]interface{}{}
+				for key, value := range params {
+					if key != "index" && key != "alias" {
+						options[key] = value
+					}
+				}
+				index, _ := params["index"].(string)
+				alias, _ := params["alias"].(string)
+				actions = append(actions, es.AliasAction{Type: typ, Index: index, Alias: alias, Options: options})
+			}
+		}
+		return fail.Wrap(m.esBaseClient.UpdateAliases(ctx, actions))
+	case StepReindex:
+		task, err := m.esBaseClient.Reindex(ctx, string(step.Body))
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		_, err = waitTask(ctx, m.esBaseClient, task.ID)
+		return fail.Wrap(err)
+	case StepDeleteIndex:
+		return fail.Wrap(m.esBaseClient.DeleteIndex(ctx, step.Index))
+	default:
+		return fail.New(fmt.Sprintf("Unknown step type: %s", step.Type))
+	}
+}
+
+func (s migrationStep) detail(dir string) ([]byte, error) {
+	if s.DetailFile != "" {
+		b, err := ioutil.ReadFile(filepath.Join(dir, s.DetailFile))
+		return b, fail.Wrap(err)
+	}
+	if len(s.Detail) == 0 {
+		return nil, fail.New(fmt.Sprintf("Detail is required for %s", s.Type))
+	}
+	return s.Detail, nil
+}
@@ -0,0 +1,257 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeMigrationFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadMigrationFiles(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name     string
+		files    []string
+		want     []string
+		hasError bool
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name:  "Ordered by number",
+			files: []string{"10_c.up.json", "2_b.up.json", "0001_a.up.json", "0001_a.down.json", "README.md"},
+			want:  []string{"0001_a", "2_b", "10_c"},
+		},
+		{
+			name:     "Up file is missing",
+			files:    []string{"1_a.up.json", "2_b.down.json"},
+			hasError: true,
+		},
+		{
+			name:     "Same version by different names",
+			files:    []string{"1_a.up.json", "1_b.up.json"},
+			hasError: true,
+		},
+		{
+			name:     "Same version by leading zeros",
+			files:    []string{"0001_a.up.json", "1_b.up.json"},
+			hasError: true,
+		},
+		{
+			name:     "Same version and name by leading zeros",
+			files:    []string{"0001_a.up.json", "1_a.down.json"},
+			hasError: true,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			files := map[string]string{}
+			for _, name := range inOut.files {
+				files[name] = `{"steps": []}`
+			}
+
+			got, err := loadMigrationFiles(writeMigrationFiles(t, files))
+			if (err != nil) != inOut.hasError {
+				t.Fatalf("hasError want %v, got %v", inOut.hasError, err)
+			}
+			if inOut.hasError {
+				return
+			}
+			names := []string{}
+			for _, file := range got {
+				names = append(names, file.version+"_"+file.name)
+			}
+			if diff := cmp.Diff(inOut.want, names); diff != "" {
+				t.Errorf("Not match order, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	t.Parallel()
+
+	up := `{"steps": [{"type": "delete_index", "index": "orders_v1"}]}`
+	dir := writeMigrationFiles(t, map[string]string{
+		"0001_create.up.json": up,
+		"2_modified.up.json":  up,
+		"3_pending.up.json":   up,
+	})
+	sum, err := checksum(filepath.Join(dir, "0001_create.up.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appliedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	record, err := json.Marshal(map[string]interface{}{"found": true, "_source": migrationRecord{Applied: []appliedMigration{
+		// The version which is recorded by another padding is the same version
+		{Version: "1", Name: "create", Checksum: sum, AppliedAt: appliedAt},
+		{Version: "2", Name: "modified", Checksum: "old", AppliedAt: appliedAt},
+		{Version: "4", Name: "missing", Checksum: sum, AppliedAt: appliedAt},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, baseClient := newFakeServer(t, "7.17.0", map[string][]fakeResponse{
+		"GET /es-cli-metadata/_doc/migrations": respond(string(record)),
+	})
+
+	got, err := NewMigration(baseClient, nil).Status(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MigrationStatus{
+		{Version: "0001", Name: "create", Applied: true, AppliedAt: appliedAt},
+		{Version: "2", Name: "modified", Applied: true, AppliedAt: appliedAt, Modified: true},
+		{Version: "3", Name: "pending"},
+		{Version: "4", Name: "missing", Applied: true, AppliedAt: appliedAt, Missing: true},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Not match status, diff(-want, +got) %s", diff)
+	}
+}
+
+func TestMigrationUpDown(t *testing.T) {
+	t.Parallel()
+
+	dir := writeMigrationFiles(t, map[string]string{
+		"1_a.up.json":   `{"steps": [{"type": "create_index", "index": "a", "detail": {}}]}`,
+		"1_a.down.json": `{"steps": [{"type": "delete_index", "index": "a"}]}`,
+		"2_b.up.json":   `{"steps": [{"type": "create_index", "index": "b", "detail": {}}]}`,
+		"10_c.up.json":  `{"steps": [{"type": "create_index", "index": "c", "detail": {}}]}`,
+	})
+	record, err := json.Marshal(map[string]interface{}{"found": true, "_source": migrationRecord{Applied: []appliedMigration{
+		{Version: "1", Name: "a"},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upRecord, err := json.Marshal(map[string]interface{}{"found": true, "_source": migrationRecord{Applied: []appliedMigration{
+		{Version: "1", Name: "a"}, {Version: "2", Name: "b"}, {Version: "10", Name: "c"},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, baseClient := newFakeServer(t, "7.17.0", map[string][]fakeResponse{
+		"GET /es-cli-metadata/_doc/migrations": respond(string(record), string(upRecord)),
+		"PUT /es-cli-metadata/_doc/migrations": respond(`{"result": "updated"}`),
+		"PUT /b":                               respond(`{"acknowledged": true}`),
+		"PUT /c":                               respond(`{"acknowledged": true}`),
+	})
+	migration := NewMigration(baseClient, nil)
+
+	// Pending migrations are applied in order of number, and recorded one by one
+	err = migration.Up(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"GET /es-cli-metadata/_doc/migrations",
+		"PUT /b", "PUT /es-cli-metadata/_doc/migrations",
+		"PUT /c", "PUT /es-cli-metadata/_doc/migrations",
+	}
+	if diff := cmp.Diff(want, server.calls()); diff != "" {
+		t.Errorf("Not match requests, diff(-want, +got) %s", diff)
+	}
+	records := server.bodies("PUT /es-cli-metadata/_doc/migrations")
+	last := migrationRecord{}
+	if err := json.Unmarshal([]byte(records[len(records)-1]), &last); err != nil {
+		t.Fatal(err)
+	}
+	versions := []string{}
+	for _, applied := range last.Applied {
+		versions = append(versions, applied.Version)
+	}
+	if diff := cmp.Diff([]string{"1", "2", "10"}, versions); diff != "" {
+		t.Errorf("Not match applied versions, diff(-want, +got) %s", diff)
+	}
+
+	// Down of the last migration which does not have the down file fails before anything is reverted
+	err = migration.Down(context.Background(), dir, 1)
+	if err == nil {
+		t.Fatal("Down without down file must fail")
+	}
+	if got := server.calls()[len(want):]; len(got) != 1 {
+		t.Errorf("Only the record must be read, got %v", got)
+	}
+}
+
+// updateRecorder records options of Update instead of updating.
+type updateRecorder struct {
+	Detail
+	opts []UpdateOpt
+}
+
+func (r *updateRecorder) Update(ctx context.Context, name string, detail io.Reader, opt UpdateOpt) error {
+	r.opts = append(r.opts, opt)
+	return nil
+}
+
+func TestMigrationUpdateDetailSHA(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name     string
+		step     string
+		want     []UpdateOpt
+		hasError bool
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name: "SHA",
+			step: `{"type": "update_detail", "index": "orders", "detail": {}, "name_template": "{name}-{sha}", "sha": "1a2b3c4"}`,
+			want: []UpdateOpt{{NameTemplate: "{name}-{sha}", SHA: "1a2b3c4"}},
+		},
+		{
+			name:     "SHA is missing",
+			step:     `{"type": "update_detail", "index": "orders", "detail": {}, "name_template": "sha"}`,
+			hasError: true,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			dir := writeMigrationFiles(t, map[string]string{
+				// The first step must not be applied when the second step is invalid
+				"1_update.up.json": `{"steps": [{"type": "delete_index", "index": "orders_v1"}, ` + inOut.step + `]}`,
+			})
+			server, baseClient := newFakeServer(t, "7.17.0", map[string][]fakeResponse{
+				"GET /es-cli-metadata/_doc/migrations": respond(`{"found": false}`),
+				"PUT /es-cli-metadata/_doc/migrations": respond(`{"result": "created"}`),
+				"DELETE /orders_v1":                    respond(`{"acknowledged": true}`),
+			})
+			recorder := &updateRecorder{}
+
+			err := NewMigration(baseClient, recorder).Up(context.Background(), dir)
+			if (err != nil) != inOut.hasError {
+				t.Fatalf("hasError want %v, got %v", inOut.hasError, err)
+			}
+			if diff := cmp.Diff(inOut.want, recorder.opts); diff != "" {
+				t.Errorf("Not match options, diff(-want, +got) %s", diff)
+			}
+			if inOut.hasError && len(server.bodies("DELETE /orders_v1")) != 0 {
+				t.Errorf("Steps must not be applied, got %v", server.calls())
+			}
+		})
+	}
+}
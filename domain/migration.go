@@ -0,0 +1,229 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rerost/es-cli/infra/es"
+	"github.com/srvc/fail"
+	"go.uber.org/zap"
+)
+
+const (
+	// SafeModeBlock blocks writes to old indices while copying. Writes fail instead of being lost
+	SafeModeBlock = "block"
+	// SafeModeCatchUp copies documents modified while copying again by the timestamp field. Writes are blocked only while the last pass
+	SafeModeCatchUp = "catch-up"
+
+	// catchUpMargin absorbs clock skew between es-cli and Elasticsearch, and refresh interval
+	catchUpMargin = time.Minute
+	// maxCatchUpPasses limits catch-up passes before switching aliases while documents are still written
+	maxCatchUpPasses = 5
+)
+
+type UpdateOpt struct {
+	// SafeMode is block or catch-up. Writes while reindexing are lost when it is empty
+	SafeMode string
+	// TimestampField is a date field which is updated on each write. It is required for catch-up
+	TimestampField string
+	// KeepOld is number of previous generations of indices kept for rollback. Old indices are deleted when it is 0
+	KeepOld int
+	// NameTemplate is timestamp, version, sha or a template which has {name}, {timestamp}, {version} and {sha}
+	NameTemplate string
+	// SHA is used for {sha}. e.g. git commit SHA of the detail file
+	SHA string
+}
+
+func (o UpdateOpt) validate() error {
+	if o.KeepOld < 0 {
+		return fail.New("Keep old must not be negative")
+	}
+	switch o.SafeMode {
+	case "", SafeModeBlock:
+	case SafeModeCatchUp:
+		if o.TimestampField == "" {
+			return fail.New("Timestamp field is required for catch-up")
+		}
+	default:
+		return fail.New(fmt.Sprintf("Unknown safe mode: %s", o.SafeMode))
+	}
+	return nil
+}
+
+type indexMigration struct {
+	oldIndex string
+	newIndex string
+}
+
+// migrator copies old indices to new indices. It rolls back on failure until commit.
+type migrator struct {
+	esBaseClient es.BaseClient
+	indexDomain  Index
+	opt          UpdateOpt
+	migrations   []indexMigration
+
+	created   []string
+	blocked   []string
+	since     time.Time
+	committed bool
+}
+
+func (d detailImpl) newMigrator(opt UpdateOpt, migrations []indexMigration) *migrator {
+	return &migrator{
+		esBaseClient: d.esBaseClient,
+		indexDomain:  d.indexDomain,
+		opt:          opt,
+		migrations:   migrations,
+	}
+}
+
+// copy creates new indices and copies documents.
+func (m *migrator) copy(ctx context.Context, detailJSON string) error {
+	for _, migration := range m.migrations {
+		err := m.esBaseClient.CreateIndex(ctx, migration.newIndex, detailJSON)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		m.created = append(m.created, migration.newIndex)
+	}
+
+	if m.opt.SafeMode == SafeModeBlock {
+		for _, migration := range m.migrations {
+			zap.L().Warn("Writes are blocked until update is done", zap.String("index", migration.oldIndex))
+			err := setWriteBlock(ctx, m.esBaseClient, migration.oldIndex, true)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+			m.blocked = append(m.blocked, migration.oldIndex)
+		}
+	}
+
+	m.since = time.Now().Add(-catchUpMargin)
+	for _, migration := range m.migrations {
+		if m.opt.SafeMode != SafeModeCatchUp {
+			// Copy checks document count, so documents must not be written
+			err := m.indexDomain.Copy(ctx, migration.oldIndex, migration.newIndex)
+			if err != nil {
+				return fail.Wrap(err)
+			}
+			continue
+		}
+		_, err := m.reindex(ctx, migration, nil)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+	}
+
+	if m.opt.SafeMode == SafeModeCatchUp {
+		return fail.Wrap(m.catchUp(ctx))
+	}
+	return nil
+}
+
+// catchUp copies documents modified after the previous pass until few documents are modified.
+// Then writes to old indices are blocked and the last pass copies the rest, so that no write is lost before aliases are switched.
+func (m *migrator) catchUp(ctx context.Context) error {
+	for pass := 1; pass <= maxCatchUpPasses; pass++ {
+		total, err := m.reindexSince(ctx)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		zap.L().Info("Caught up", zap.Int("pass", pass), zap.Int("documents", total))
+		if total == 0 {
+			break
+		}
+	}
+
+	for _, migration := range m.migrations {
+		zap.L().Warn("Writes are blocked until aliases are switched", zap.String("index", migration.oldIndex))
+		err := setWriteBlock(ctx, m.esBaseClient, migration.oldIndex, true)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		m.blocked = append(m.blocked, migration.oldIndex)
+	}
+	total, err := m.reindexSince(ctx)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	zap.L().Info("Caught up while writes are blocked", zap.Int("documents", total))
+	return nil
+}
+
+func (m *migrator) reindexSince(ctx context.Context) (int, error) {
+	since := m.since
+	m.since = time.Now().Add(-catchUpMargin)
+
+	total := 0
+	for _, migration := range m.migrations {
+		query := map[string]interface{}{
+			"range": map[string]interface{}{
+				m.opt.TimestampField: map[string]interface{}{"gte": since.UTC().Format(time.RFC3339)},
+			},
+		}
+		n, err := m.reindex(ctx, migration, query)
+		if err != nil {
+			return total, fail.Wrap(err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// reindex copies documents. Documents which are already copied are overwritten by newer ones of the old index.
+func (m *migrator) reindex(ctx context.Context, migration indexMigration, query map[string]interface{}) (int, error) {
+	source := map[string]interface{}{"index": migration.oldIndex}
+	if query != nil {
+		source["query"] = query
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"source": source,
+		"dest":   map[string]interface{}{"index": migration.newIndex, "op_type": "index"},
+	})
+	if err != nil {
+		return 0, fail.Wrap(err)
+	}
+
+	task, err := m.esBaseClient.Reindex(ctx, string(body))
+	if err != nil {
+		return 0, fail.Wrap(err)
+	}
+	task, err = waitTask(ctx, m.esBaseClient, task.ID)
+	if err != nil {
+		return 0, fail.Wrap(err)
+	}
+	return task.Total, nil
+}
+
+// commit is called when old indices can not be restored anymore. e.g. aliases are switched
+func (m *migrator) commit() {
+	m.committed = true
+}
+
+// rollbackOnError unblocks old indices and deletes new indices when *errp is not nil and not committed.
+func (m *migrator) rollbackOnError(ctx context.Context, errp *error) {
+	if *errp == nil || m.committed {
+		return
+	}
+	zap.L().Warn("Rollback", zap.Strings("unblock", m.blocked), zap.Strings("delete", m.created))
+	for _, index := range m.blocked {
+		if err := setWriteBlock(ctx, m.esBaseClient, index, false); err != nil {
+			zap.L().Error("Failed to unblock writes", zap.String("index", index), zap.Error(err))
+		}
+	}
+	for _, index := range m.created {
+		if err := m.esBaseClient.DeleteIndex(ctx, index); err != nil {
+			zap.L().Error("Failed to delete index", zap.String("index", index), zap.Error(err))
+		}
+	}
+}
+
+func setWriteBlock(ctx context.Context, esBaseClient es.BaseClient, indexName string, block bool) error {
+	body, err := json.Marshal(map[string]interface{}{"index.blocks.write": block})
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	return fail.Wrap(esBaseClient.PutSettings(ctx, indexName, string(body)))
+}
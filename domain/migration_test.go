@@ -0,0 +1,147 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/srvc/fail"
+)
+
+func TestMigratorCatchUp(t *testing.T) {
+	t.Parallel()
+
+	server, baseClient := newFakeServer(t, "7.17.0", map[string][]fakeResponse{
+		"PUT /orders_v2":           respond(`{"acknowledged": true}`),
+		"PUT /orders_v1/_settings": respond(`{"acknowledged": true}`),
+		"POST /_reindex":           respond(`{"task": "t1"}`),
+		"GET /_tasks/t1":           respond(`{"completed": true, "response": {"total": 1}}`, `{"completed": true, "response": {"total": 0}}`),
+	})
+	d := detailImpl{esBaseClient: baseClient, indexDomain: NewIndex(baseClient)}
+	m := d.newMigrator(UpdateOpt{SafeMode: SafeModeCatchUp, TimestampField: "updated_at"}, []indexMigration{{oldIndex: "orders_v1", newIndex: "orders_v2"}})
+
+	err := m.copy(context.Background(), `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Full copy, a catch-up pass which copies nothing, then the last pass while writes are blocked
+	want := []string{
+		"PUT /orders_v2",
+		"POST /_reindex", "GET /_tasks/t1",
+		"POST /_reindex", "GET /_tasks/t1",
+		"PUT /orders_v1/_settings",
+		"POST /_reindex", "GET /_tasks/t1",
+	}
+	if diff := cmp.Diff(want, server.calls()); diff != "" {
+		t.Errorf("Not match requests, diff(-want, +got) %s", diff)
+	}
+	if diff := cmp.Diff([]string{`{"index.blocks.write":true}`}, server.bodies("PUT /orders_v1/_settings")); diff != "" {
+		t.Errorf("Not match settings, diff(-want, +got) %s", diff)
+	}
+	if diff := cmp.Diff([]string{"orders_v1"}, m.blocked); diff != "" {
+		t.Errorf("Blocked indices must be unblocked on rollback, diff(-want, +got) %s", diff)
+	}
+
+	reindexes := server.bodies("POST /_reindex")
+	last := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(reindexes[len(reindexes)-1]), &last); err != nil {
+		t.Fatal(err)
+	}
+	// Documents updated after the previous pass overwrite copied ones
+	if diff := cmp.Diff(map[string]interface{}{"index": "orders_v2", "op_type": "index"}, last["dest"]); diff != "" {
+		t.Errorf("Not match dest, diff(-want, +got) %s", diff)
+	}
+	source := last["source"].(map[string]interface{})
+	if _, ok := source["query"].(map[string]interface{})["range"].(map[string]interface{})["updated_at"]; !ok {
+		t.Errorf("Last pass must copy documents by the timestamp field, got %v", source)
+	}
+}
+
+func TestMigratorRollback(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name      string
+		opt       UpdateOpt
+		responses map[string][]fakeResponse
+		commit    bool
+		want      []string
+		settings  []string
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name: "Blocked index is unblocked and new index is deleted",
+			opt:  UpdateOpt{SafeMode: SafeModeBlock},
+			responses: map[string][]fakeResponse{
+				"POST /_reindex": {{status: 500, body: `{"error": "failed"}`}},
+			},
+			want: []string{
+				"PUT /orders_v2", "PUT /orders_v1/_settings", "GET /_aliases", "POST /_reindex",
+				"PUT /orders_v1/_settings", "DELETE /orders_v2",
+			},
+			settings: []string{`{"index.blocks.write":true}`, `{"index.blocks.write":false}`},
+		},
+		{
+			name: "Catch-up is unblocked when the last pass fails",
+			opt:  UpdateOpt{SafeMode: SafeModeCatchUp, TimestampField: "updated_at"},
+			responses: map[string][]fakeResponse{
+				"POST /_reindex": {{body: `{"task": "t1"}`}, {body: `{"task": "t1"}`}, {status: 500, body: `{"error": "failed"}`}},
+			},
+			want: []string{
+				"PUT /orders_v2", "POST /_reindex", "GET /_tasks/t1", "POST /_reindex", "GET /_tasks/t1",
+				"PUT /orders_v1/_settings", "POST /_reindex",
+				"PUT /orders_v1/_settings", "DELETE /orders_v2",
+			},
+			settings: []string{`{"index.blocks.write":true}`, `{"index.blocks.write":false}`},
+		},
+		{
+			name: "Nothing is rolled back after commit",
+			opt:  UpdateOpt{SafeMode: SafeModeBlock},
+			responses: map[string][]fakeResponse{
+				"POST /_reindex": {{status: 500, body: `{"error": "failed"}`}},
+			},
+			commit:   true,
+			want:     []string{"PUT /orders_v2", "PUT /orders_v1/_settings", "GET /_aliases", "POST /_reindex"},
+			settings: []string{`{"index.blocks.write":true}`},
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			responses := map[string][]fakeResponse{
+				"PUT /orders_v2":           respond(`{"acknowledged": true}`),
+				"PUT /orders_v1/_settings": respond(`{"acknowledged": true}`),
+				"GET /_aliases":            respond(`{"orders_v1": {"aliases": {}}, "orders_v2": {"aliases": {}}}`),
+				"GET /_tasks/t1":           respond(`{"completed": true, "response": {"total": 0}}`),
+				"DELETE /orders_v2":        respond(`{"acknowledged": true}`),
+			}
+			for key, response := range inOut.responses {
+				responses[key] = response
+			}
+			server, baseClient := newFakeServer(t, "7.17.0", responses)
+			d := detailImpl{esBaseClient: baseClient, indexDomain: NewIndex(baseClient)}
+			m := d.newMigrator(inOut.opt, []indexMigration{{oldIndex: "orders_v1", newIndex: "orders_v2"}})
+
+			err := func() (err error) {
+				defer m.rollbackOnError(context.Background(), &err)
+				if inOut.commit {
+					m.commit()
+				}
+				return fail.Wrap(m.copy(context.Background(), `{}`))
+			}()
+			if err == nil {
+				t.Fatal("Copy must fail")
+			}
+			if diff := cmp.Diff(inOut.want, server.calls()); diff != "" {
+				t.Errorf("Not match requests, diff(-want, +got) %s", diff)
+			}
+			if diff := cmp.Diff(inOut.settings, server.bodies("PUT /orders_v1/_settings")); diff != "" {
+				t.Errorf("Not match settings, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
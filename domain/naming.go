@@ -0,0 +1,162 @@
+package domain
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/srvc/fail"
+)
+
+const (
+	// NameTimestamp is {name}_{timestamp}. e.g. orders_20261018_150405
+	NameTimestamp = "timestamp"
+	// NameVersion is {name}_v{version}. e.g. orders_v2 => orders_v3
+	NameVersion = "version"
+	// NameSHA is {name}_{sha}. e.g. orders_1a2b3c4
+	NameSHA = "sha"
+
+	timestampFormat = "20060102_150405"
+)
+
+var (
+	timestampSuffix = regexp.MustCompile(`^(.+)_\d{8}_\d{6}$`)
+	versionSuffix   = regexp.MustCompile(`^(.+)([_-])v(\d+)$`)
+)
+
+// indexName is an index name split to the base name and suffixes. e.g. orders_v2 => orders, _, 2
+type indexName struct {
+	base      string
+	separator string
+	version   int
+}
+
+func parseIndexName(name string) indexName {
+	if m := timestampSuffix.FindStringSubmatch(name); m != nil {
+		name = m[1]
+	}
+	if m := versionSuffix.FindStringSubmatch(name); m != nil {
+		version, _ := strconv.Atoi(m[3])
+		return indexName{base: m[1], separator: m[2], version: version}
+	}
+	return indexName{base: name, separator: "_"}
+}
+
+// indexNamer returns names of new indices from the template.
+type indexNamer struct {
+	template string
+	sha      string
+	now      time.Time
+	exists   map[string]bool
+}
+
+func newIndexNamer(template string, sha string, existingIndices []string) *indexNamer {
+	exists := make(map[string]bool, len(existingIndices))
+	for _, index := range existingIndices {
+		exists[index] = true
+	}
+	return &indexNamer{template: template, sha: sha, now: time.Now(), exists: exists}
+}
+
+// name returns a new index name. base is used as {name} when it is not empty, otherwise the base of oldIndex.
+// Version is incremented from oldIndex until the name does not exist.
+func (n *indexNamer) name(base string, oldIndex string) (string, error) {
+	parsed := parseIndexName(oldIndex)
+
+	template := expandNameTemplate(n.template, parsed.separator)
+	if !strings.Contains(template, "{") {
+		return "", fail.New(fmt.Sprintf("Unknown name template: %s", n.template))
+	}
+	if strings.Contains(template, "{sha}") && n.sha == "" {
+		return "", fail.New("SHA is required for the name template")
+	}
+
+	// Old index which is named by the same template is parsed by the template
+	if matched, ok := parseIndexNameWithTemplate(template, oldIndex); ok {
+		parsed = matched
+	}
+	if base == "" {
+		base = parsed.base
+	}
+
+	// Original index is version 1
+	version := parsed.version
+	if version == 0 {
+		version = 1
+	}
+	for {
+		version++
+		name := strings.NewReplacer(
+			"{name}", base,
+			"{timestamp}", n.now.Format(timestampFormat),
+			"{sha}", n.sha,
+			"{version}", strconv.Itoa(version),
+		).Replace(template)
+
+		if !n.exists[name] {
+			n.exists[name] = true
+			return name, nil
+		}
+		if !strings.Contains(template, "{version}") {
+			return "", fail.New(fmt.Sprintf("Index %s already exists", name))
+		}
+	}
+}
+
+// expandNameTemplate returns the template of timestamp, version or sha. Others are returned as is.
+func expandNameTemplate(template string, separator string) string {
+	switch template {
+	case NameTimestamp, "":
+		return "{name}_{timestamp}"
+	case NameVersion:
+		return "{name}" + separator + "v{version}"
+	case NameSHA:
+		return "{name}_{sha}"
+	default:
+		return template
+	}
+}
+
+// parseIndexNameByTemplate parses name by the template, or by the suffixes when it does not match.
+func parseIndexNameByTemplate(template string, name string) indexName {
+	parsed := parseIndexName(name)
+	if template == "" {
+		return parsed
+	}
+	if matched, ok := parseIndexNameWithTemplate(expandNameTemplate(template, parsed.separator), name); ok {
+		return matched
+	}
+	return parsed
+}
+
+// parseIndexNameWithTemplate parses name by the template. e.g. orders-1a2b3c4-v2 by {name}-{sha}-v{version}
+func parseIndexNameWithTemplate(template string, name string) (indexName, bool) {
+	// QuoteMeta escapes braces, so placeholders are replaced after quoting
+	pattern := "^" + strings.NewReplacer(
+		`\{name\}`, `(?P<name>.+?)`,
+		`\{timestamp\}`, `\d{8}_\d{6}`,
+		`\{version\}`, `(?P<version>\d+)`,
+		`\{sha\}`, `[0-9a-f]{7,40}`,
+	).Replace(regexp.QuoteMeta(template)) + "$"
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return indexName{}, false
+	}
+	m := re.FindStringSubmatch(name)
+	if m == nil {
+		return indexName{}, false
+	}
+	parsed := indexName{}
+	for n, group := range re.SubexpNames() {
+		switch group {
+		case "name":
+			parsed.base = m[n]
+		case "version":
+			parsed.version, _ = strconv.Atoi(m[n])
+		}
+	}
+	return parsed, parsed.base != ""
+}
@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIndexNamerName(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2026, 10, 18, 15, 4, 5, 0, time.UTC)
+
+	type InOutPairs struct {
+		name     string
+		template string
+		base     string
+		oldIndex string
+		exists   []string
+		want     string
+	}
+	inOutPairs := []InOutPairs{
+		{name: "timestamp", template: NameTimestamp, base: "orders", oldIndex: "orders_20250101_000000", want: "orders_20261018_150405"},
+		{name: "timestamp replaces timestamp of old index", template: NameTimestamp, oldIndex: "orders_v2_20250101_000000", want: "orders_v2_20261018_150405"},
+		{name: "version from timestamp", template: NameVersion, oldIndex: "orders_v2_20250101_000000", want: "orders_v3"},
+		{name: "version", template: NameVersion, oldIndex: "orders_v2", want: "orders_v3"},
+		{name: "version with hyphen", template: NameVersion, oldIndex: "orders-v9", want: "orders-v10"},
+		{name: "version without suffix", template: NameVersion, oldIndex: "orders", want: "orders_v2"},
+		{name: "version skips existing", template: NameVersion, oldIndex: "orders_v2", exists: []string{"orders_v3"}, want: "orders_v4"},
+		{name: "sha", template: NameSHA, base: "orders", oldIndex: "orders_v2", want: "orders_1a2b3c4"},
+		{name: "custom", template: "{name}-{sha}-v{version}", oldIndex: "orders-1a2b3c4-v1", want: "orders-1a2b3c4-v2"},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			namer := newIndexNamer(inOut.template, "1a2b3c4", inOut.exists)
+			namer.now = now
+			got, err := namer.name(inOut.base, inOut.oldIndex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != inOut.want {
+				t.Errorf("want %s, got %s", inOut.want, got)
+			}
+		})
+	}
+}
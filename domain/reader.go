@@ -0,0 +1,272 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rerost/es-cli/infra/es"
+	"github.com/srvc/fail"
+)
+
+const (
+	defaultKeepAlive = "5m"
+	// DefaultSampleSeed is the seed of random sampling when it is not specified
+	DefaultSampleSeed = 20190101
+)
+
+// documentReader reads all documents of an index page by page.
+type documentReader interface {
+	// next returns empty hits when all documents are read.
+	next(ctx context.Context) ([]es.SearchHit, error)
+	close(ctx context.Context) error
+}
+
+type readerOpt struct {
+	// search is a search body which is used instead of query, includes and excludes. Its sort is kept before the tiebreaker
+	search    map[string]interface{}
+	query     map[string]interface{}
+	includes  []string
+	excludes  []string
+	size      int
+	keepAlive string
+	// slice is used when max > 1
+	sliceID  int
+	sliceMax int
+}
+
+func (o readerOpt) withDefault() readerOpt {
+	if o.keepAlive == "" {
+		o.keepAlive = defaultKeepAlive
+	}
+	if o.size <= 0 {
+		o.size = BATCH_SIZE
+	}
+	return o
+}
+
+func (o readerOpt) body() map[string]interface{} {
+	if o.search != nil {
+		body := make(map[string]interface{}, len(o.search)+1)
+		for key, value := range o.search {
+			body[key] = value
+		}
+		if _, ok := body["size"]; !ok {
+			body["size"] = o.size
+		}
+		return body
+	}
+
+	body := map[string]interface{}{
+		"query": o.query,
+		"size":  o.size,
+	}
+	if o.query == nil {
+		body["query"] = map[string]interface{}{"match_all": map[string]interface{}{}}
+	}
+	if len(o.includes) > 0 || len(o.excludes) > 0 {
+		source := map[string]interface{}{}
+		if len(o.includes) > 0 {
+			source["includes"] = o.includes
+		}
+		if len(o.excludes) > 0 {
+			source["excludes"] = o.excludes
+		}
+		body["_source"] = source
+	}
+	if o.sliceMax > 1 {
+		body["slice"] = map[string]interface{}{"id": o.sliceID, "max": o.sliceMax}
+	}
+	return body
+}
+
+// sampleQuery wraps query so that each document matches with probability ratio.
+// The same seed samples the same documents while the index is not changed.
+func sampleQuery(query map[string]interface{}, ratio float64, seed int64) map[string]interface{} {
+	if query == nil {
+		query = map[string]interface{}{"match_all": map[string]interface{}{}}
+	}
+	return map[string]interface{}{
+		"function_score": map[string]interface{}{
+			"query": query,
+			// random_score is [0, 1)
+			"random_score": map[string]interface{}{"seed": seed, "field": "_seq_no"},
+			"boost_mode":   "replace",
+			"min_score":    1 - ratio,
+		},
+	}
+}
+
+// parseQuery parses a query clause. A search body which has "query" is also accepted.
+func parseQuery(b []byte) (map[string]interface{}, error) {
+	query := map[string]interface{}{}
+	err := json.Unmarshal(b, &query)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	if q, ok := query["query"].(map[string]interface{}); ok {
+		return q, nil
+	}
+	return query, nil
+}
+
+// pitReader reads documents by search_after with point in time. All slices share the same point in time, so they read a consistent snapshot.
+type pitReader struct {
+	esBaseClient es.BaseClient
+	pitID        string
+	opt          readerOpt
+	searchAfter  []interface{}
+}
+
+func (r *pitReader) next(ctx context.Context) ([]es.SearchHit, error) {
+	body := r.opt.body()
+	body["pit"] = map[string]interface{}{"id": r.pitID, "keep_alive": r.opt.keepAlive}
+	body["sort"] = append(sortClauses(body), "_shard_doc")
+	if r.searchAfter != nil {
+		body["search_after"] = r.searchAfter
+	}
+
+	query, err := json.Marshal(body)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	result, err := r.esBaseClient.SearchPointInTime(ctx, string(query))
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	if result.PitID != "" {
+		r.pitID = result.PitID
+	}
+	hits := result.Hits.Hits
+	if len(hits) > 0 {
+		r.searchAfter = hits[len(hits)-1].Sort
+	}
+	return hits, nil
+}
+
+// close does nothing, because the point in time is shared between slices.
+func (r *pitReader) close(ctx context.Context) error {
+	return nil
+}
+
+// scrollReader reads documents by scroll. It is used for clusters which do not support point in time.
+// It is not sliced, because each sliced scroll reads its own snapshot.
+type scrollReader struct {
+	esBaseClient es.BaseClient
+	indexName    string
+	scrollID     string
+	opt          readerOpt
+}
+
+func (r *scrollReader) next(ctx context.Context) ([]es.SearchHit, error) {
+	var result es.SearchResponse
+	var err error
+	if r.scrollID == "" {
+		body := r.opt.body()
+		// Scroll returns each hit once by any sort, and _doc is the cheapest
+		if _, ok := body["sort"]; !ok {
+			body["sort"] = []interface{}{"_doc"}
+		}
+		query, err := json.Marshal(body)
+		if err != nil {
+			return nil, fail.Wrap(err)
+		}
+		result, err = r.esBaseClient.Scroll(ctx, r.indexName, string(query), r.opt.keepAlive)
+		if err != nil {
+			return nil, fail.Wrap(err)
+		}
+	} else {
+		result, err = r.esBaseClient.ScrollNext(ctx, r.scrollID, r.opt.keepAlive)
+		if err != nil {
+			return nil, fail.Wrap(err)
+		}
+	}
+
+	if result.ScrollID != "" {
+		r.scrollID = result.ScrollID
+	}
+	return result.Hits.Hits, nil
+}
+
+func (r *scrollReader) close(ctx context.Context) error {
+	if r.scrollID == "" {
+		return nil
+	}
+	return fail.Wrap(r.esBaseClient.ClearScroll(ctx, r.scrollID))
+}
+
+// checkPointInTime fails when the point in time has expired. A new point in time can not continue from search_after of the old one, because _shard_doc differs by snapshots.
+func checkPointInTime(ctx context.Context, esBaseClient es.BaseClient, pitID string, keepAlive string) error {
+	if keepAlive == "" {
+		keepAlive = defaultKeepAlive
+	}
+	query, err := json.Marshal(map[string]interface{}{
+		"pit":  map[string]interface{}{"id": pitID, "keep_alive": keepAlive},
+		"size": 0,
+	})
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	_, err = esBaseClient.SearchPointInTime(ctx, string(query))
+	if es.IsNotFound(err) {
+		return fail.New("Point in time of the checkpoint has expired, so dump can not be resumed. It is kept alive for --keep-alive after the failure. Dump again without --resume")
+	}
+	return fail.Wrap(err)
+}
+
+// resumeReaders returns readers which continue from checkpoint. Point in time must be alive.
+func resumeReaders(esBaseClient es.BaseClient, checkpoint dumpCheckpoint, opt readerOpt) []documentReader {
+	opt = opt.withDefault()
+	readers := make([]documentReader, len(checkpoint.Slices))
+	for n, slice := range checkpoint.Slices {
+		sliceOpt := opt
+		sliceOpt.sliceID, sliceOpt.sliceMax = n, len(checkpoint.Slices)
+		// Slices which failed before the first checkpoint do not have the id
+		pitID := slice.PitID
+		if pitID == "" {
+			pitID = checkpoint.PitID
+		}
+		readers[n] = &pitReader{esBaseClient: esBaseClient, pitID: pitID, opt: sliceOpt, searchAfter: slice.SearchAfter}
+	}
+	return readers
+}
+
+// openReaders returns readers for each slice. closeAll must be called after reading.
+func openReaders(ctx context.Context, esBaseClient es.BaseClient, indexName string, opt readerOpt, slices int) (readers []documentReader, closeAll func(ctx context.Context) error, err error) {
+	if slices < 1 {
+		slices = 1
+	}
+	opt = opt.withDefault()
+
+	info, err := esBaseClient.ServerInfo(ctx)
+	if err != nil {
+		return nil, nil, fail.Wrap(err)
+	}
+
+	if slices > 1 && !info.PointInTime(true) {
+		// Sliced scrolls do not share a snapshot, so documents written while dumping may be duplicated or lost
+		return nil, nil, fail.New(fmt.Sprintf("Slices require sliced point in time of Elasticsearch 7.15 or later, and OpenSearch is not supported, but the cluster is %s", info))
+	}
+
+	readers = make([]documentReader, slices)
+	if info.PointInTime(slices > 1) {
+		pitID, err := esBaseClient.OpenPointInTime(ctx, indexName, opt.keepAlive)
+		if err != nil {
+			return nil, nil, fail.Wrap(err)
+		}
+		for n := range readers {
+			sliceOpt := opt
+			sliceOpt.sliceID, sliceOpt.sliceMax = n, slices
+			readers[n] = &pitReader{esBaseClient: esBaseClient, pitID: pitID, opt: sliceOpt}
+		}
+		return readers, func(ctx context.Context) error {
+			return fail.Wrap(esBaseClient.ClosePointInTime(ctx, pitID))
+		}, nil
+	}
+
+	reader := &scrollReader{esBaseClient: esBaseClient, indexName: indexName, opt: opt}
+	readers[0] = reader
+	return readers, reader.close, nil
+}
@@ -0,0 +1,157 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rerost/es-cli/config"
+)
+
+// readAll reads pages until an empty page, and returns _id of each page.
+func readAll(ctx context.Context, t *testing.T, r documentReader) [][]string {
+	pages := [][]string{}
+	for {
+		hits, err := r.next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hits) == 0 {
+			return pages
+		}
+		ids := []string{}
+		for _, hit := range hits {
+			ids = append(ids, hit.ID)
+		}
+		pages = append(pages, ids)
+	}
+}
+
+func TestPitReader(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	server, baseClient := newFakeServer(t, "7.17.0", map[string][]fakeResponse{
+		"POST /orders/_pit": respond(`{"id": "p1"}`),
+		"POST /_search": respond(
+			`{"pit_id": "p2", "hits": {"total": {"value": 3}, "hits": [{"_id": "1", "sort": [1]}, {"_id": "2", "sort": [2]}]}}`,
+			`{"pit_id": "p2", "hits": {"total": {"value": 3}, "hits": [{"_id": "3", "sort": [3]}]}}`,
+			`{"pit_id": "p2", "hits": {"total": {"value": 3}, "hits": []}}`,
+		),
+		"DELETE /_pit": respond(`{"succeeded": true}`),
+	})
+
+	readers, closeAll, err := openReaders(ctx, baseClient, "orders", readerOpt{size: 2}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([][]string{{"1", "2"}, {"3"}}, readAll(ctx, t, readers[1])); diff != "" {
+		t.Errorf("Not match hits, diff(-want, +got) %s", diff)
+	}
+	if err := closeAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	type body struct {
+		Pit         map[string]interface{} `json:"pit"`
+		Slice       map[string]interface{} `json:"slice"`
+		Sort        []interface{}          `json:"sort"`
+		SearchAfter []interface{}          `json:"search_after"`
+	}
+	got := []body{}
+	for _, b := range server.bodies("POST /_search") {
+		var parsed body
+		if err := json.Unmarshal([]byte(b), &parsed); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, parsed)
+	}
+	// The latest point in time and the sort of the last hit are used for the next page
+	slice := map[string]interface{}{"id": 1.0, "max": 2.0}
+	sort := []interface{}{"_shard_doc"}
+	want := []body{
+		{Pit: map[string]interface{}{"id": "p1", "keep_alive": "5m"}, Slice: slice, Sort: sort},
+		{Pit: map[string]interface{}{"id": "p2", "keep_alive": "5m"}, Slice: slice, Sort: sort, SearchAfter: []interface{}{2.0}},
+		{Pit: map[string]interface{}{"id": "p2", "keep_alive": "5m"}, Slice: slice, Sort: sort, SearchAfter: []interface{}{3.0}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Not match search bodies, diff(-want, +got) %s", diff)
+	}
+	// The point in time which is opened is closed, even if it is renewed
+	if diff := cmp.Diff([]string{`{"id":"p1"}`}, server.bodies("DELETE /_pit")); diff != "" {
+		t.Errorf("Not match closed point in time, diff(-want, +got) %s", diff)
+	}
+}
+
+func TestScrollReader(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	server, baseClient := newFakeServer(t, "6.8.0", map[string][]fakeResponse{
+		"POST /orders/_search": respond(`{"_scroll_id": "s1", "hits": {"total": 3, "hits": [{"_id": "1"}, {"_id": "2"}]}}`),
+		"POST /_search/scroll": respond(
+			`{"_scroll_id": "s2", "hits": {"total": 3, "hits": [{"_id": "3"}]}}`,
+			`{"_scroll_id": "s2", "hits": {"total": 3, "hits": []}}`,
+		),
+		"DELETE /_search/scroll": respond(`{"succeeded": true}`),
+	})
+
+	readers, closeAll, err := openReaders(ctx, baseClient, "orders", readerOpt{size: 2}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([][]string{{"1", "2"}, {"3"}}, readAll(ctx, t, readers[0])); diff != "" {
+		t.Errorf("Not match hits, diff(-want, +got) %s", diff)
+	}
+	if err := closeAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"POST /orders/_search", "POST /_search/scroll", "POST /_search/scroll", "DELETE /_search/scroll"}
+	if diff := cmp.Diff(want, server.calls()); diff != "" {
+		t.Errorf("Not match requests, diff(-want, +got) %s", diff)
+	}
+	if diff := cmp.Diff([]string{`{"scroll":"5m","scroll_id":"s1"}`, `{"scroll":"5m","scroll_id":"s2"}`}, server.bodies("POST /_search/scroll")); diff != "" {
+		t.Errorf("Not match scroll ids, diff(-want, +got) %s", diff)
+	}
+}
+
+func TestOpenReadersSlices(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		version      string
+		distribution string
+		hasError     bool
+	}
+	inOutPairs := []InOutPairs{
+		{version: "6.8.0", hasError: true},
+		{version: "7.12.0", hasError: true},
+		{version: "7.15.0", hasError: false},
+		{version: "2.11.0", distribution: "opensearch", hasError: true},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.version, func(t *testing.T) {
+			t.Parallel()
+			root := fmt.Sprintf(`{"version": {"number": %q, "distribution": %q}}`, inOut.version, inOut.distribution)
+			server, baseClient := newFakeServerWithConfig(t, config.Config{Type: "_doc"}, root, map[string][]fakeResponse{
+				"POST /orders/_pit": respond(`{"id": "p1"}`),
+			})
+
+			readers, _, err := openReaders(context.Background(), baseClient, "orders", readerOpt{}, 2)
+			if (err != nil) != inOut.hasError {
+				t.Fatalf("hasError want %v, got %v", inOut.hasError, err)
+			}
+			if inOut.hasError && len(server.calls()) != 0 {
+				t.Errorf("Sliced scroll must not be opened, got %v", server.calls())
+			}
+			if !inOut.hasError && len(readers) != 2 {
+				t.Errorf("Readers want 2, got %d", len(readers))
+			}
+		})
+	}
+}
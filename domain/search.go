@@ -0,0 +1,150 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/srvc/fail"
+	"go.uber.org/zap"
+)
+
+type SearchOpt struct {
+	// Body is a search body of query DSL. Default is match_all
+	Body []byte
+	// QueryString is a Lucene query string. It is combined with the query of Body. e.g. user.name:alice AND age:>20
+	QueryString string
+	// Size, From and Sort overwrite ones of Body when set. Size is the page size when All is set
+	Size int
+	From int
+	// Sort are field or field:order. e.g. created_at:desc
+	Sort []string
+	// All reads all hits by point in time, or by scroll for clusters which do not support it
+	All bool
+	// Output is the format of hits
+	Output ExportOpt
+}
+
+// body returns the search body which Body is overwritten by options.
+func (o SearchOpt) body() (map[string]interface{}, error) {
+	body := map[string]interface{}{}
+	if len(o.Body) > 0 {
+		err := json.Unmarshal(o.Body, &body)
+		if err != nil {
+			return nil, fail.Wrap(err)
+		}
+	}
+
+	if o.QueryString != "" {
+		queryString := map[string]interface{}{"query_string": map[string]interface{}{"query": o.QueryString}}
+		if query, ok := body["query"]; ok {
+			body["query"] = map[string]interface{}{"bool": map[string]interface{}{"must": []interface{}{query, queryString}}}
+		} else {
+			body["query"] = queryString
+		}
+	}
+	if o.Size > 0 {
+		body["size"] = o.Size
+	}
+	if o.From > 0 {
+		body["from"] = o.From
+	}
+	if len(o.Sort) > 0 {
+		body["sort"] = parseSort(o.Sort)
+	}
+	return body, nil
+}
+
+// parseSort converts field:order to sort of query DSL. e.g. created_at:desc => {"created_at": {"order": "desc"}}
+func parseSort(sort []string) []interface{} {
+	result := make([]interface{}, len(sort))
+	for n, s := range sort {
+		i := strings.LastIndex(s, ":")
+		if i < 0 {
+			result[n] = s
+			continue
+		}
+		result[n] = map[string]interface{}{s[:i]: map[string]interface{}{"order": s[i+1:]}}
+	}
+	return result
+}
+
+// sortClauses returns sort of the body as a list. Sort of query DSL is also a string or an object.
+func sortClauses(body map[string]interface{}) []interface{} {
+	switch sort := body["sort"].(type) {
+	case nil:
+		return []interface{}{}
+	case []interface{}:
+		return sort
+	default:
+		return []interface{}{sort}
+	}
+}
+
+func (i indexImpl) Search(ctx context.Context, indexName string, fp io.Writer, opt SearchOpt) error {
+	body, err := opt.body()
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	if opt.Output.Format == "" {
+		opt.Output.Format = FormatJSONL
+	}
+	w, err := newRecordWriter(fp, opt.Output)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	if !opt.All {
+		query, err := json.Marshal(body)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		result, err := i.esBaseClient.SearchIndex(ctx, indexName, string(query))
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		zap.L().Debug("Searched", zap.Int64("total", int64(result.Hits.Total)), zap.Int("hits", len(result.Hits.Hits)))
+
+		err = w.write(result.Hits.Hits)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		return fail.Wrap(w.flush())
+	}
+
+	if _, ok := body["from"]; ok {
+		return fail.New("from can not be used with all, because all hits are read page by page")
+	}
+
+	// Point in time, or scroll for clusters which do not support it, reads a snapshot, so that each hit is returned once
+	readers, closeAll, err := openReaders(ctx, i.esBaseClient, indexName, readerOpt{search: body}, 1)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	defer func() {
+		if err := closeAll(ctx); err != nil {
+			zap.L().Warn("Failed to close search", zap.Error(err))
+		}
+	}()
+
+	searched := 0
+	for {
+		hits, err := readers[0].next(ctx)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		if len(hits) == 0 {
+			break
+		}
+
+		err = w.write(hits)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		searched += len(hits)
+		zap.L().Debug("Searched", zap.Int("hits", searched))
+	}
+
+	return fail.Wrap(w.flush())
+}
@@ -0,0 +1,146 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSearchBody(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name string
+		opt  SearchOpt
+		want string
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name: "Empty",
+			opt:  SearchOpt{},
+			want: `{}`,
+		},
+		{
+			name: "Query string",
+			opt:  SearchOpt{QueryString: "name:alice", Size: 5, Sort: []string{"created_at:desc", "_score"}},
+			want: `{"query":{"query_string":{"query":"name:alice"}},"size":5,"sort":[{"created_at":{"order":"desc"}},"_score"]}`,
+		},
+		{
+			name: "Query string with body",
+			opt:  SearchOpt{Body: []byte(`{"query":{"term":{"n":1}},"size":3,"from":2}`), QueryString: "name:alice", From: 4},
+			want: `{"from":4,"query":{"bool":{"must":[{"term":{"n":1}},{"query_string":{"query":"name:alice"}}]}},"size":3}`,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			body, err := inOut.opt.body()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != inOut.want {
+				t.Errorf("Not match body, want %s, got %s", inOut.want, b)
+			}
+		})
+	}
+}
+
+func TestSearchAllPointInTime(t *testing.T) {
+	t.Parallel()
+
+	server, baseClient := newFakeServer(t, "7.17.0", map[string][]fakeResponse{
+		"POST /orders/_pit": respond(`{"id": "p1"}`),
+		"POST /_search": respond(
+			`{"hits": {"total": 3, "hits": [{"_id": "1", "_source": {"n": 1}, "sort": [3, 10]}, {"_id": "2", "_source": {"n": 2}, "sort": [2, 11]}]}}`,
+			`{"hits": {"total": 3, "hits": [{"_id": "3", "_source": {"n": 3}, "sort": [1, 12]}]}}`,
+			`{"hits": {"total": 3, "hits": []}}`,
+		),
+		"DELETE /_pit": respond(`{"succeeded": true}`),
+	})
+	indexDomain := indexImpl{esBaseClient: baseClient}
+
+	buf := &bytes.Buffer{}
+	err := indexDomain.Search(context.Background(), "orders", buf, SearchOpt{Size: 2, Sort: []string{"n:desc"}, All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "{\"_id\":\"1\",\"n\":1}\n{\"_id\":\"2\",\"n\":2}\n{\"_id\":\"3\",\"n\":3}\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Not match output, want %q, got %q", want, got)
+	}
+	calls := []string{"POST /orders/_pit", "POST /_search", "POST /_search", "POST /_search", "DELETE /_pit"}
+	if diff := cmp.Diff(calls, server.calls()); diff != "" {
+		t.Errorf("Not match requests, diff(-want, +got) %s", diff)
+	}
+	if diff := cmp.Diff([]string{`{"id":"p1"}`}, server.bodies("DELETE /_pit")); diff != "" {
+		t.Errorf("Not match closed point in time, diff(-want, +got) %s", diff)
+	}
+
+	type body struct {
+		Size        float64       `json:"size"`
+		Sort        []interface{} `json:"sort"`
+		SearchAfter []interface{} `json:"search_after"`
+	}
+	got := []body{}
+	for _, b := range server.bodies("POST /_search") {
+		var parsed body
+		if err := json.Unmarshal([]byte(b), &parsed); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, parsed)
+	}
+	// The sort of the user is kept before the tiebreaker, and the sort of the last hit is used for the next page
+	sort := []interface{}{map[string]interface{}{"n": map[string]interface{}{"order": "desc"}}, "_shard_doc"}
+	wantBodies := []body{
+		{Size: 2, Sort: sort},
+		{Size: 2, Sort: sort, SearchAfter: []interface{}{2.0, 11.0}},
+		{Size: 2, Sort: sort, SearchAfter: []interface{}{1.0, 12.0}},
+	}
+	if diff := cmp.Diff(wantBodies, got); diff != "" {
+		t.Errorf("Not match search bodies, diff(-want, +got) %s", diff)
+	}
+}
+
+func TestSearchAllScroll(t *testing.T) {
+	t.Parallel()
+
+	// Two shards return the same sort values, and _doc of them is also the same across the page boundary.
+	// search_after would skip the hit of the second shard, but scroll returns it.
+	server, baseClient := newFakeServer(t, "6.8.0", map[string][]fakeResponse{
+		"POST /orders/_search": respond(`{"_scroll_id": "s1", "hits": {"total": 4, "hits": [{"_id": "1", "_shard": "[orders][0]", "_source": {"n": 5}, "sort": [5, 0]}, {"_id": "2", "_shard": "[orders][0]", "_source": {"n": 5}, "sort": [5, 1]}]}}`),
+		"POST /_search/scroll": respond(
+			`{"_scroll_id": "s1", "hits": {"total": 4, "hits": [{"_id": "3", "_shard": "[orders][1]", "_source": {"n": 5}, "sort": [5, 1]}, {"_id": "4", "_shard": "[orders][1]", "_source": {"n": 5}, "sort": [5, 2]}]}}`,
+			`{"_scroll_id": "s1", "hits": {"total": 4, "hits": []}}`,
+		),
+		"DELETE /_search/scroll": respond(`{"succeeded": true}`),
+	})
+	indexDomain := indexImpl{esBaseClient: baseClient}
+
+	buf := &bytes.Buffer{}
+	err := indexDomain.Search(context.Background(), "orders", buf, SearchOpt{Size: 2, Sort: []string{"n:desc"}, All: true, Output: ExportOpt{Format: FormatCSV, Fields: []string{"_id"}, NoHeader: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := buf.String(); got != "1\n2\n3\n4\n" {
+		t.Errorf("All hits must be returned, got %q", got)
+	}
+	calls := []string{"POST /orders/_search", "POST /_search/scroll", "POST /_search/scroll", "DELETE /_search/scroll"}
+	if diff := cmp.Diff(calls, server.calls()); diff != "" {
+		t.Errorf("Not match requests, diff(-want, +got) %s", diff)
+	}
+	// The sort of the user is used as is, without search_after
+	if diff := cmp.Diff([]string{`{"size":2,"sort":[{"n":{"order":"desc"}}]}`}, server.bodies("POST /orders/_search")); diff != "" {
+		t.Errorf("Not match search bodies, diff(-want, +got) %s", diff)
+	}
+}
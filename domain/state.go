@@ -0,0 +1,592 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rerost/es-cli/infra/es"
+	"github.com/srvc/fail"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	KindIndex     = "index"
+	KindAlias     = "alias"
+	KindTemplate  = "template"
+	KindPipeline  = "pipeline"
+	KindILMPolicy = "ilm_policy"
+
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionReindex = "reindex"
+	ActionPut     = "put"
+	ActionAdd     = "add"
+	ActionRemove  = "remove"
+	ActionDelete  = "delete"
+)
+
+// DesiredState is the desired state of a cluster.
+// Keys of Indices are indices or aliases which are updated by update detail. Aliases are alias names to index names.
+type DesiredState struct {
+	Indices     map[string]interface{} `json:"indices" yaml:"indices"`
+	Aliases     map[string][]string    `json:"aliases" yaml:"aliases"`
+	Templates   map[string]interface{} `json:"templates" yaml:"templates"`
+	Pipelines   map[string]interface{} `json:"pipelines" yaml:"pipelines"`
+	ILMPolicies map[string]interface{} `json:"ilm_policies" yaml:"ilm_policies"`
+}
+
+// LoadState reads a YAML or JSON state file, or a directory which is written by WriteState.
+func LoadState(path string) (DesiredState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return DesiredState{}, fail.Wrap(err)
+	}
+	if info.IsDir() {
+		return loadStateDir(path)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return DesiredState{}, fail.Wrap(err)
+	}
+	return parseState(b)
+}
+
+func parseState(b []byte) (DesiredState, error) {
+	// JSON is YAML
+	node := yamlNode{}
+	err := yaml.Unmarshal(b, &node)
+	if err != nil {
+		return DesiredState{}, fail.Wrap(err)
+	}
+	b, err = json.Marshal(node.value)
+	if err != nil {
+		return DesiredState{}, fail.Wrap(err)
+	}
+	state := DesiredState{}
+	err = json.Unmarshal(b, &state)
+	return state, fail.Wrap(err)
+}
+
+// yamlNode decodes YAML to values which can be marshaled to JSON.
+// Keys are decoded as strings, so that keys such as "n" and "on" are not booleans.
+type yamlNode struct {
+	value interface{}
+}
+
+func (n *yamlNode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	object := map[string]yamlNode{}
+	if err := unmarshal(&object); err == nil {
+		values := make(map[string]interface{}, len(object))
+		for key, child := range object {
+			values[key] = child.value
+		}
+		n.value = values
+		return nil
+	}
+	array := []yamlNode{}
+	if err := unmarshal(&array); err == nil {
+		values := make([]interface{}, len(array))
+		for i, child := range array {
+			values[i] = child.value
+		}
+		n.value = values
+		return nil
+	}
+	return unmarshal(&n.value)
+}
+
+type StateOpt struct {
+	// Prune deletes indices, aliases, templates, pipelines and policies which are not in the state. Names starting with "." and resources installed by Elasticsearch are not deleted
+	Prune bool
+}
+
+// StateOperation is an operation to make the cluster the desired state.
+type StateOperation struct {
+	Action  string
+	Kind    string
+	Name    string
+	Detail  string
+	Changes []Change
+
+	apply func(ctx context.Context) error
+}
+
+func (o StateOperation) String() string {
+	mark := map[string]string{
+		ActionCreate: "+", ActionAdd: "+", ActionPut: "~", ActionUpdate: "~",
+		ActionReindex: "-/+", ActionRemove: "-", ActionDelete: "-",
+	}[o.Action]
+	line := fmt.Sprintf("%s %s %s %s", mark, o.Action, o.Kind, o.Name)
+	if o.Detail != "" {
+		line += " " + o.Detail
+	}
+	lines := []string{line}
+	for _, change := range o.Changes {
+		lines = append(lines, "    "+change.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+type State interface {
+	// Plan returns operations in order of apply
+	Plan(ctx context.Context, desired DesiredState, opt StateOpt) ([]StateOperation, error)
+	Apply(ctx context.Context, desired DesiredState, opt StateOpt) error
+	Export(ctx context.Context, opt ExportStateOpt) (DesiredState, error)
+}
+
+func NewState(esBaseClient es.BaseClient, detailDomain Detail) State {
+	return stateImpl{
+		esBaseClient: esBaseClient,
+		detailDomain: detailDomain,
+	}
+}
+
+type stateImpl struct {
+	esBaseClient es.BaseClient
+	detailDomain Detail
+}
+
+func (s stateImpl) Apply(ctx context.Context, desired DesiredState, opt StateOpt) error {
+	operations, err := s.Plan(ctx, desired, opt)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	for _, operation := range operations {
+		fmt.Println(operation)
+		err = operation.apply(ctx)
+		if err != nil {
+			return fail.Wrap(err, fail.WithParam(operation.Kind, operation.Name))
+		}
+	}
+	fmt.Printf("Applied %d operations\n", len(operations))
+	return nil
+}
+
+// Plan orders operations by dependency. Policies and pipelines are used by templates and indices, templates are used by new indices, and aliases point to indices.
+func (s stateImpl) Plan(ctx context.Context, desired DesiredState, opt StateOpt) ([]StateOperation, error) {
+	operations := []StateOperation{}
+
+	policies, err := s.planResources(ctx, KindILMPolicy, desired.ILMPolicies, opt)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	pipelines, err := s.planResources(ctx, KindPipeline, desired.Pipelines, opt)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	templates, err := s.planResources(ctx, KindTemplate, desired.Templates, opt)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	indices, managed, err := s.planIndices(ctx, desired, opt)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	aliases, err := s.planAliases(ctx, desired, managed, opt)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	// Deletions are applied after aliases are removed and before policies are deleted
+	for _, group := range [][]StateOperation{policies, pipelines, templates, indices} {
+		for _, operation := range group {
+			if operation.Action != ActionDelete {
+				operations = append(operations, operation)
+			}
+		}
+	}
+	operations = append(operations, aliases...)
+	for _, group := range [][]StateOperation{indices, templates, pipelines, policies} {
+		for _, operation := range group {
+			if operation.Action == ActionDelete {
+				operations = append(operations, operation)
+			}
+		}
+	}
+	return operations, nil
+}
+
+// planIndices returns operations of indices and names of indices which are managed by the state.
+func (s stateImpl) planIndices(ctx context.Context, desired DesiredState, opt StateOpt) ([]StateOperation, map[string]bool, error) {
+	operations := []StateOperation{}
+	managed := map[string]bool{}
+
+	for _, name := range sortedKeys(desired.Indices) {
+		name := name
+		detail, err := json.Marshal(desired.Indices[name])
+		if err != nil {
+			return nil, nil, fail.Wrap(err)
+		}
+		// Aliases in the detail are managed by update detail
+		if object, ok := desired.Indices[name].(map[string]interface{}); ok {
+			for _, alias := range sortedKeys(object["aliases"]) {
+				managed[alias] = true
+			}
+		}
+
+		indices, err := s.esBaseClient.ListAlias(ctx, name)
+		if es.IsNotFound(err) {
+			managed[name] = true
+			operations = append(operations, StateOperation{
+				Action: ActionCreate, Kind: KindIndex, Name: name,
+				apply: func(ctx context.Context) error {
+					return fail.Wrap(s.esBaseClient.CreateIndex(ctx, name, string(detail)))
+				},
+			})
+			continue
+		}
+		if err != nil {
+			return nil, nil, fail.Wrap(err)
+		}
+		managed[name] = true
+		for _, index := range indices {
+			managed[index.Name] = true
+		}
+
+		plans, err := s.detailDomain.Plan(ctx, name, bytes.NewReader(detail))
+		if err != nil {
+			return nil, nil, fail.Wrap(err)
+		}
+		changes := []Change{}
+		action := ActionUpdate
+		for _, plan := range plans {
+			changes = append(changes, plan.Changes...)
+			if plan.Reindex() {
+				action = ActionReindex
+			}
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		operations = append(operations, StateOperation{
+			Action: action, Kind: KindIndex, Name: name, Changes: changes,
+			apply: func(ctx context.Context) error {
+				return fail.Wrap(s.detailDomain.Update(ctx, name, bytes.NewReader(detail), UpdateOpt{}))
+			},
+		})
+	}
+
+	if !opt.Prune {
+		return operations, managed, nil
+	}
+	// Indices which aliases point to, indices created by templates such as rollover indices and old indices kept by update detail are not pruned
+	for _, indices := range desired.Aliases {
+		for _, index := range indices {
+			managed[index] = true
+		}
+	}
+	retained, err := s.retainedIndices(ctx, desired)
+	if err != nil {
+		return nil, nil, fail.Wrap(err)
+	}
+	patterns := templatePatterns(desired.Templates)
+	current, err := s.esBaseClient.ListIndex(ctx)
+	if err != nil {
+		return nil, nil, fail.Wrap(err)
+	}
+	names := []string{}
+	for _, index := range current {
+		if managed[index.Name] || retained[index.Name] || unmanagedName(index.Name) {
+			continue
+		}
+		if len(patterns) > 0 && matchState(patterns, index.Name) {
+			continue
+		}
+		names = append(names, index.Name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		name := name
+		operations = append(operations, StateOperation{
+			Action: ActionDelete, Kind: KindIndex, Name: name,
+			apply: func(ctx context.Context) error {
+				return fail.Wrap(s.esBaseClient.DeleteIndex(ctx, name))
+			},
+		})
+	}
+	return operations, managed, nil
+}
+
+// retainedIndices returns old indices of desired indices which are kept for rollback, and indices which are rolled back from.
+func (s stateImpl) retainedIndices(ctx context.Context, desired DesiredState) (map[string]bool, error) {
+	store := metadataStore{esBaseClient: s.esBaseClient}
+	retained := map[string]bool{}
+	for _, name := range sortedKeys(desired.Indices) {
+		history, err := store.aliasHistory(ctx, name)
+		if err != nil {
+			return nil, fail.Wrap(err)
+		}
+		for _, generation := range history.Generations {
+			for _, index := range generation.Indices {
+				retained[index] = true
+			}
+		}
+		for _, index := range history.RolledBack {
+			retained[index] = true
+		}
+	}
+	return retained, nil
+}
+
+// templatePatterns returns index_patterns of templates. "template" of legacy templates on 6.x is also a pattern.
+func templatePatterns(templates map[string]interface{}) []string {
+	patterns := []string{}
+	for _, name := range sortedKeys(templates) {
+		template, _ := templates[name].(map[string]interface{})
+		for _, key := range []string{"index_patterns", "template"} {
+			switch v := template[key].(type) {
+			case string:
+				patterns = append(patterns, v)
+			case []interface{}:
+				for _, pattern := range v {
+					if s, ok := pattern.(string); ok {
+						patterns = append(patterns, s)
+					}
+				}
+			}
+		}
+	}
+	return patterns
+}
+
+// planAliases returns one operation per alias. Aliases of managed names are managed by update detail.
+func (s stateImpl) planAliases(ctx context.Context, desired DesiredState, managed map[string]bool, opt StateOpt) ([]StateOperation, error) {
+	current, err := s.esBaseClient.ListAliases(ctx)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	names := map[string]bool{}
+	for name := range desired.Aliases {
+		names[name] = true
+	}
+	if opt.Prune {
+		for name := range current {
+			if !managed[name] && !unmanagedName(name) {
+				names[name] = true
+			}
+		}
+	}
+
+	operations := []StateOperation{}
+	for _, name := range sortedKeys(names) {
+		name := name
+		want := map[string]bool{}
+		for _, index := range desired.Aliases[name] {
+			want[index] = true
+		}
+		have := map[string]bool{}
+		for _, index := range current[name] {
+			have[index] = true
+		}
+
+		actions := []es.AliasAction{}
+		for _, index := range sortedKeys(want) {
+			if !have[index] {
+				actions = append(actions, es.AliasAction{Type: es.AliasActionAdd, Index: index, Alias: name})
+			}
+		}
+		for _, index := range sortedKeys(have) {
+			if !want[index] {
+				actions = append(actions, es.AliasAction{Type: es.AliasActionRemove, Index: index, Alias: name})
+			}
+		}
+		if len(actions) == 0 {
+			continue
+		}
+
+		action := ActionUpdate
+		switch {
+		case len(have) == 0:
+			action = ActionAdd
+		case len(want) == 0:
+			action = ActionRemove
+		}
+		indices := []string{}
+		for _, a := range actions {
+			indices = append(indices, a.Type+" "+a.Index)
+		}
+		operations = append(operations, StateOperation{
+			Action: action, Kind: KindAlias, Name: name, Detail: "(" + strings.Join(indices, ", ") + ")",
+			apply: func(ctx context.Context) error {
+				return fail.Wrap(s.esBaseClient.UpdateAliases(ctx, actions))
+			},
+		})
+	}
+	return operations, nil
+}
+
+// planResources returns operations of templates, pipelines or policies, which are replaced as a whole.
+func (s stateImpl) planResources(ctx context.Context, kind string, desired map[string]interface{}, opt StateOpt) ([]StateOperation, error) {
+	if len(desired) == 0 && !opt.Prune {
+		return nil, nil
+	}
+
+	var current map[string]json.RawMessage
+	var err error
+	var put func(ctx context.Context, name string, body string) error
+	var del func(ctx context.Context, name string) error
+	switch kind {
+	case KindTemplate:
+		current, err = s.esBaseClient.ListTemplates(ctx)
+		put, del = s.esBaseClient.PutTemplate, s.esBaseClient.DeleteTemplate
+	case KindPipeline:
+		current, err = s.esBaseClient.ListPipelines(ctx)
+		put, del = s.esBaseClient.PutPipeline, s.esBaseClient.DeletePipeline
+	case KindILMPolicy:
+		current, err = s.esBaseClient.ListLifecyclePolicies(ctx)
+		put, del = s.esBaseClient.PutLifecyclePolicy, s.esBaseClient.DeleteLifecyclePolicy
+	}
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	operations := []StateOperation{}
+	for _, name := range sortedKeys(desired) {
+		name := name
+		want := desired[name]
+		if kind == KindILMPolicy {
+			// {"policy": {...}} is also accepted
+			if m, ok := want.(map[string]interface{}); ok && len(m) == 1 && m["policy"] != nil {
+				want = m["policy"]
+			}
+		}
+		body, err := json.Marshal(want)
+		if err != nil {
+			return nil, fail.Wrap(err)
+		}
+		operation := StateOperation{
+			Action: ActionCreate, Kind: kind, Name: name,
+			apply: func(ctx context.Context) error {
+				return fail.Wrap(put(ctx, name, string(body)))
+			},
+		}
+
+		if raw, ok := current[name]; ok {
+			var have interface{}
+			err = json.Unmarshal(raw, &have)
+			if err != nil {
+				return nil, fail.Wrap(err)
+			}
+			operation.Action = ActionPut
+			operation.Changes = diffResource(have, want)
+			if len(operation.Changes) == 0 {
+				continue
+			}
+		}
+		operations = append(operations, operation)
+	}
+
+	if opt.Prune {
+		for _, name := range sortedKeys(current) {
+			name := name
+			if _, ok := desired[name]; ok || unmanagedName(name) {
+				continue
+			}
+			var have interface{}
+			if json.Unmarshal(current[name], &have) == nil && serverManagedResource(have) {
+				continue
+			}
+			operations = append(operations, StateOperation{
+				Action: ActionDelete, Kind: kind, Name: name,
+				apply: func(ctx context.Context) error {
+					return fail.Wrap(del(ctx, name))
+				},
+			})
+		}
+	}
+	return operations, nil
+}
+
+// diffResource compares templates or policies. Settings are normalized, and default values which are added by Elasticsearch are ignored.
+func diffResource(have, want interface{}) []Change {
+	changes := []Change{}
+	for _, change := range diffValue("", normalizeResource(have), normalizeResource(want)) {
+		if change.Kind == ChangeRemove && isDefaultValue(change.Old) {
+			continue
+		}
+		change.Path = strings.TrimPrefix(change.Path, ".")
+		change.Apply = ApplyInPlace
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func normalizeResource(value interface{}) interface{} {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	result := make(map[string]interface{}, len(object))
+	for key, child := range object {
+		switch key {
+		case "settings":
+			if settings, ok := child.(map[string]interface{}); ok {
+				flattened := map[string]interface{}{}
+				flattenSettings(flattened, "", settings)
+				result[key] = flattened
+				continue
+			}
+		case "mappings":
+			if mappings, ok := child.(map[string]interface{}); ok {
+				result[key] = typelessMapping(mappings)
+				continue
+			}
+		}
+		result[key] = normalizeResource(child)
+	}
+	return result
+}
+
+func isDefaultValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	case float64:
+		return v == 0
+	case string:
+		return v == "" || v == "0ms"
+	default:
+		return false
+	}
+}
+
+// unmanagedName returns whether the name is a system resource or es-cli metadata, which is not pruned.
+func unmanagedName(name string) bool {
+	return strings.HasPrefix(name, ".") || name == MetadataIndex
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch v := m.(type) {
+	case map[string]interface{}:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	case map[string]bool:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	case map[string]map[string]interface{}:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	case map[string]json.RawMessage:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,330 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/srvc/fail"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// stateDirs are directories of each kind in a state directory. Each file is <name>.json, <name>.yaml or <name>.yml.
+var stateDirs = []string{"indices", "aliases", "templates", "pipelines", "ilm_policies"}
+
+type ExportStateOpt struct {
+	// Match is glob patterns of names. All names are exported when it is empty
+	Match []string
+}
+
+// Export returns the current state of the cluster.
+// Server managed settings are removed. An index which is the only index of the alias named by update detail is exported with the alias name.
+func (s stateImpl) Export(ctx context.Context, opt ExportStateOpt) (DesiredState, error) {
+	state := DesiredState{
+		Indices:     map[string]interface{}{},
+		Aliases:     map[string][]string{},
+		Templates:   map[string]interface{}{},
+		Pipelines:   map[string]interface{}{},
+		ILMPolicies: map[string]interface{}{},
+	}
+
+	indices, err := s.esBaseClient.ListIndex(ctx)
+	if err != nil {
+		return DesiredState{}, fail.Wrap(err)
+	}
+	aliases, err := s.esBaseClient.ListAliases(ctx)
+	if err != nil {
+		return DesiredState{}, fail.Wrap(err)
+	}
+
+	details := map[string]map[string]interface{}{}
+	for _, index := range indices {
+		if unmanagedName(index.Name) {
+			continue
+		}
+		detail, err := s.exportDetail(ctx, index.Name)
+		if err != nil {
+			return DesiredState{}, fail.Wrap(err)
+		}
+		details[index.Name] = detail
+	}
+
+	// Aliases with options such as filter and routing are kept in details, because Aliases has only index names
+	keys := map[string]string{}
+	for alias, targets := range aliases {
+		if unmanagedName(alias) || len(targets) != 1 || details[targets[0]] == nil || hasAliasOptions(details[targets[0]], alias) {
+			continue
+		}
+		if parseIndexName(targets[0]).base == alias {
+			keys[targets[0]] = alias
+		}
+	}
+
+	for _, index := range sortedKeys(details) {
+		detail := details[index]
+		key := index
+		if alias, ok := keys[index]; ok {
+			key = alias
+		}
+		if !matchState(opt.Match, key, index) {
+			continue
+		}
+
+		withOptions := map[string]interface{}{}
+		for alias, options := range detailAliases(detail) {
+			if alias != key && len(options) > 0 {
+				withOptions[alias] = options
+			}
+		}
+		delete(detail, "aliases")
+		if len(withOptions) > 0 {
+			detail["aliases"] = withOptions
+		}
+		state.Indices[key] = detail
+	}
+
+	for alias, targets := range aliases {
+		if unmanagedName(alias) || !matchState(opt.Match, alias) {
+			continue
+		}
+		plain := []string{}
+		for _, index := range targets {
+			if keys[index] != alias && (details[index] == nil || !hasAliasOptions(details[index], alias)) {
+				plain = append(plain, index)
+			}
+		}
+		if len(plain) > 0 {
+			sort.Strings(plain)
+			state.Aliases[alias] = plain
+		}
+	}
+
+	for kind, resources := range map[string]map[string]interface{}{KindTemplate: state.Templates, KindPipeline: state.Pipelines, KindILMPolicy: state.ILMPolicies} {
+		err = s.exportResources(ctx, kind, resources, opt)
+		if err != nil {
+			return DesiredState{}, fail.Wrap(err)
+		}
+	}
+
+	return state, nil
+}
+
+// exportDetail returns the detail of the index without server managed settings.
+func (s stateImpl) exportDetail(ctx context.Context, index string) (map[string]interface{}, error) {
+	detail, err := s.esBaseClient.DetailIndex(ctx, index)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	detail, err = creatableDetail(detail)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	b, err := json.Marshal(detail)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	result := map[string]interface{}{}
+	err = json.Unmarshal(b, &result)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	for key, value := range result {
+		if isDefaultValue(value) {
+			delete(result, key)
+		}
+	}
+	return result, nil
+}
+
+func detailAliases(detail map[string]interface{}) map[string]map[string]interface{} {
+	result := map[string]map[string]interface{}{}
+	aliases, _ := detail["aliases"].(map[string]interface{})
+	for alias, options := range aliases {
+		result[alias], _ = options.(map[string]interface{})
+	}
+	return result
+}
+
+func hasAliasOptions(detail map[string]interface{}, alias string) bool {
+	return len(detailAliases(detail)[alias]) > 0
+}
+
+func (s stateImpl) exportResources(ctx context.Context, kind string, resources map[string]interface{}, opt ExportStateOpt) error {
+	var current map[string]json.RawMessage
+	var err error
+	switch kind {
+	case KindTemplate:
+		current, err = s.esBaseClient.ListTemplates(ctx)
+	case KindPipeline:
+		current, err = s.esBaseClient.ListPipelines(ctx)
+	case KindILMPolicy:
+		current, err = s.esBaseClient.ListLifecyclePolicies(ctx)
+	}
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	for name, raw := range current {
+		if unmanagedName(name) || !matchState(opt.Match, name) {
+			continue
+		}
+		var resource interface{}
+		err = json.Unmarshal(raw, &resource)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		if serverManagedResource(resource) {
+			continue
+		}
+		resources[name] = resource
+	}
+	return nil
+}
+
+// serverManagedResource returns whether the resource is installed by Elasticsearch. e.g. {"_meta": {"managed": true}}
+func serverManagedResource(resource interface{}) bool {
+	object, _ := resource.(map[string]interface{})
+	meta, _ := object["_meta"].(map[string]interface{})
+	managed, _ := meta["managed"].(bool)
+	return managed
+}
+
+// matchState returns whether any of names matches any of patterns.
+func matchState(patterns []string, names ...string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type WriteStateOpt struct {
+	// Clean removes state files in dir before writing. Otherwise WriteState fails when they exist, so that removed objects are not left
+	Clean bool
+}
+
+// WriteState writes one JSON file per object into directories of each kind under dir.
+func WriteState(dir string, state DesiredState, opt WriteStateOpt) error {
+	files, err := stateFiles(dir)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	if len(files) > 0 && !opt.Clean {
+		return fail.New(fmt.Sprintf("State files already exist in %s. Specify --clean to remove them, or an empty directory", dir))
+	}
+	for _, file := range files {
+		err := os.Remove(file)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+	}
+
+	objects := map[string]map[string]interface{}{
+		"indices":      state.Indices,
+		"aliases":      {},
+		"templates":    state.Templates,
+		"pipelines":    state.Pipelines,
+		"ilm_policies": state.ILMPolicies,
+	}
+	for alias, indices := range state.Aliases {
+		objects["aliases"][alias] = indices
+	}
+
+	for _, kind := range stateDirs {
+		if len(objects[kind]) == 0 {
+			continue
+		}
+		err := os.MkdirAll(filepath.Join(dir, kind), 0755)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+		for name, object := range objects[kind] {
+			b, err := json.MarshalIndent(object, "", "  ")
+			if err != nil {
+				return fail.Wrap(err)
+			}
+			err = ioutil.WriteFile(filepath.Join(dir, kind, name+".json"), append(b, '\n'), 0644)
+			if err != nil {
+				return fail.Wrap(err, fail.WithParam("name", name))
+			}
+		}
+	}
+	return nil
+}
+
+// stateFiles returns paths of state files in directories of each kind under dir.
+func stateFiles(dir string) ([]string, error) {
+	files := []string{}
+	for _, kind := range stateDirs {
+		entries, err := ioutil.ReadDir(filepath.Join(dir, kind))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fail.Wrap(err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && stateFile(entry.Name()) {
+				files = append(files, filepath.Join(dir, kind, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+func stateFile(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == ".json" || ext == ".yaml" || ext == ".yml"
+}
+
+func loadStateDir(dir string) (DesiredState, error) {
+	objects := map[string]interface{}{}
+	for _, kind := range stateDirs {
+		entries, err := ioutil.ReadDir(filepath.Join(dir, kind))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return DesiredState{}, fail.Wrap(err)
+		}
+
+		values := map[string]interface{}{}
+		for _, entry := range entries {
+			if entry.IsDir() || !stateFile(entry.Name()) {
+				continue
+			}
+			ext := filepath.Ext(entry.Name())
+			b, err := ioutil.ReadFile(filepath.Join(dir, kind, entry.Name()))
+			if err != nil {
+				return DesiredState{}, fail.Wrap(err)
+			}
+			node := yamlNode{}
+			err = yaml.Unmarshal(b, &node)
+			if err != nil {
+				return DesiredState{}, fail.Wrap(err, fail.WithParam("file", entry.Name()))
+			}
+			values[strings.TrimSuffix(entry.Name(), ext)] = node.value
+		}
+		objects[kind] = values
+	}
+
+	b, err := json.Marshal(objects)
+	if err != nil {
+		return DesiredState{}, fail.Wrap(err)
+	}
+	state := DesiredState{}
+	err = json.Unmarshal(b, &state)
+	return state, fail.Wrap(err)
+}
@@ -0,0 +1,272 @@
+package domain
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseState(t *testing.T) {
+	t.Parallel()
+
+	yamlState := `
+indices:
+  orders:
+    mappings:
+      properties:
+        n: {type: long}
+        on: {type: boolean}
+aliases:
+  orders_read: [orders]
+`
+	jsonState := `{"indices": {"orders": {"mappings": {"properties": {"n": {"type": "long"}, "on": {"type": "boolean"}}}}}, "aliases": {"orders_read": ["orders"]}}`
+
+	want := DesiredState{
+		Indices: map[string]interface{}{
+			"orders": map[string]interface{}{
+				"mappings": map[string]interface{}{
+					"properties": map[string]interface{}{
+						"n":  map[string]interface{}{"type": "long"},
+						"on": map[string]interface{}{"type": "boolean"},
+					},
+				},
+			},
+		},
+		Aliases: map[string][]string{"orders_read": {"orders"}},
+	}
+
+	for _, in := range []string{yamlState, jsonState} {
+		got, err := parseState([]byte(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Not match state, diff(-want, +got) %s", diff)
+		}
+	}
+}
+
+func TestWriteStateLoadState(t *testing.T) {
+	t.Parallel()
+
+	want := DesiredState{
+		Indices: map[string]interface{}{
+			"orders": map[string]interface{}{"settings": map[string]interface{}{"index": map[string]interface{}{"number_of_shards": "1"}}},
+		},
+		Aliases:     map[string][]string{"orders_read": {"orders"}},
+		Templates:   map[string]interface{}{"logs": map[string]interface{}{"index_patterns": []interface{}{"logs-*"}}},
+		Pipelines:   map[string]interface{}{"set": map[string]interface{}{"processors": []interface{}{}}},
+		ILMPolicies: map[string]interface{}{"logs": map[string]interface{}{"phases": map[string]interface{}{}}},
+	}
+
+	dir := t.TempDir()
+	if err := WriteState(dir, want, WriteStateOpt{}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Not match state, diff(-want, +got) %s", diff)
+	}
+}
+
+func TestWriteStateStaleFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	old := DesiredState{Indices: map[string]interface{}{
+		"orders": map[string]interface{}{"mappings": map[string]interface{}{}},
+		"users":  map[string]interface{}{"mappings": map[string]interface{}{}},
+	}}
+	if err := WriteState(dir, old, WriteStateOpt{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "indices", "README.md"), []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := DesiredState{Indices: map[string]interface{}{
+		"orders": map[string]interface{}{"mappings": map[string]interface{}{}},
+	}}
+	if err := WriteState(dir, want, WriteStateOpt{}); err == nil {
+		t.Fatal("Writing to the directory which has state files must fail")
+	}
+	if err := WriteState(dir, want, WriteStateOpt{Clean: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Not match state, diff(-want, +got) %s", diff)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "indices", "README.md")); err != nil {
+		t.Errorf("Files other than state files must be kept, got %v", err)
+	}
+}
+
+func TestHasAliasOptions(t *testing.T) {
+	t.Parallel()
+
+	detail := map[string]interface{}{"aliases": map[string]interface{}{
+		"orders":       map[string]interface{}{},
+		"orders_27":    map[string]interface{}{"filter": map[string]interface{}{"term": map[string]interface{}{"shop": 27}}},
+		"orders_write": map[string]interface{}{"is_write_index": true},
+	}}
+
+	type InOutPairs struct {
+		alias string
+		want  bool
+	}
+	inOutPairs := []InOutPairs{
+		{alias: "orders", want: false},
+		{alias: "orders_27", want: true},
+		{alias: "orders_write", want: true},
+		{alias: "missing", want: false},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.alias, func(t *testing.T) {
+			t.Parallel()
+			if got := hasAliasOptions(detail, inOut.alias); got != inOut.want {
+				t.Errorf("want %v, got %v", inOut.want, got)
+			}
+		})
+	}
+}
+
+func TestExportState(t *testing.T) {
+	t.Parallel()
+
+	_, baseClient := newFakeServer(t, "7.17.0", map[string][]fakeResponse{
+		"GET /_aliases": respond(`{
+			"orders_20261018_150405": {"aliases": {"orders": {}}},
+			"users_v2": {"aliases": {"users": {}}},
+			"logs": {"aliases": {"logs_read": {}}},
+			".kibana": {"aliases": {".kibana_read": {}}}
+		}`),
+		"GET /orders_20261018_150405": respond(`{"orders_20261018_150405": {"settings": {"index": {"number_of_shards": "1", "uuid": "u", "creation_date": "1", "provided_name": "orders_20261018_150405", "version": {"created": "1"}}}, "mappings": {}, "aliases": {"orders": {}}}}`),
+		"GET /users_v2":               respond(`{"users_v2": {"settings": {"index": {"number_of_shards": "1"}}, "mappings": {}, "aliases": {"users": {"filter": {"term": {"active": true}}}}}}`),
+		"GET /logs":                   respond(`{"logs": {"settings": {"index": {"number_of_shards": "1"}}, "mappings": {}, "aliases": {"logs_read": {}}}}`),
+		"GET /_index_template":        respond(`{"index_templates": []}`),
+		"GET /_ingest/pipeline":       respond(`{}`),
+		"GET /_ilm/policy":            respond(`{}`),
+	})
+
+	got, err := stateImpl{esBaseClient: baseClient}.Export(context.Background(), ExportStateOpt{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := map[string]interface{}{"index": map[string]interface{}{"number_of_shards": "1"}}
+	want := DesiredState{
+		Indices: map[string]interface{}{
+			// The only index of the alias named by update detail is exported with the alias name
+			"orders": map[string]interface{}{"settings": settings},
+			// The alias with options is kept in the detail
+			"users_v2": map[string]interface{}{"settings": settings, "aliases": map[string]interface{}{
+				"users": map[string]interface{}{"filter": map[string]interface{}{"term": map[string]interface{}{"active": true}}},
+			}},
+			"logs": map[string]interface{}{"settings": settings},
+		},
+		Aliases:     map[string][]string{"logs_read": {"logs"}},
+		Templates:   map[string]interface{}{},
+		Pipelines:   map[string]interface{}{},
+		ILMPolicies: map[string]interface{}{},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Not match state, diff(-want, +got) %s", diff)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	t.Parallel()
+
+	orders := `{"orders_v1": {"aliases": {"orders": {}}, "mappings": {}, "settings": {"index": {"number_of_shards": "1", "number_of_replicas": "1"}}}}`
+	_, baseClient := newFakeServer(t, "7.17.0", map[string][]fakeResponse{
+		"GET /_ilm/policy":                       respond(`{"old_policy": {"policy": {"phases": {}}}}`),
+		"GET /_ingest/pipeline":                  respond(`{"managed": {"_meta": {"managed": true}}}`),
+		"GET /_index_template":                   respond(`{"index_templates": [{"name": "logs", "index_template": {"index_patterns": ["logs-*"]}}, {"name": "old_template", "index_template": {"index_patterns": ["old-*"]}}]}`),
+		"GET /orders":                            respond(orders),
+		"GET /orders_v1":                         respond(orders),
+		"GET /es-cli-metadata/_doc/alias:orders": respond(`{"_id": "alias:orders", "found": true, "_source": {"alias": "orders", "generations": [{"indices": ["orders_v0"], "replaced_by": ["orders_v1"]}]}}`),
+		"GET /_aliases": respond(`{
+			"orders_v1": {"aliases": {"orders": {}}},
+			"orders_v0": {"aliases": {}},
+			"logs-000001": {"aliases": {}},
+			"stale": {"aliases": {"old_alias": {}}},
+			".kibana": {"aliases": {}},
+			"es-cli-metadata": {"aliases": {}}
+		}`),
+	})
+	st := NewState(baseClient, NewDetail(baseClient, NewIndex(baseClient)))
+
+	desired := DesiredState{
+		Indices: map[string]interface{}{
+			"orders": map[string]interface{}{"settings": map[string]interface{}{"number_of_replicas": 2}},
+			"users":  map[string]interface{}{},
+		},
+		Aliases:     map[string][]string{"orders_read": {"orders_v1"}},
+		Templates:   map[string]interface{}{"logs": map[string]interface{}{"index_patterns": []interface{}{"logs-*"}}},
+		ILMPolicies: map[string]interface{}{"logs": map[string]interface{}{"phases": map[string]interface{}{}}},
+	}
+
+	type InOutPairs struct {
+		name string
+		opt  StateOpt
+		want []string
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name: "Without prune",
+			opt:  StateOpt{},
+			want: []string{
+				"create ilm_policy logs",
+				"update index orders",
+				"create index users",
+				"add alias orders_read",
+			},
+		},
+		{
+			name: "Prune keeps indices of templates and indices kept for rollback",
+			opt:  StateOpt{Prune: true},
+			want: []string{
+				"create ilm_policy logs",
+				"update index orders",
+				"create index users",
+				"remove alias old_alias",
+				"add alias orders_read",
+				"delete index stale",
+				"delete template old_template",
+				"delete ilm_policy old_policy",
+			},
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			operations, err := st.Plan(context.Background(), desired, inOut.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(operations))
+			for n, operation := range operations {
+				got[n] = operation.Action + " " + operation.Kind + " " + operation.Name
+			}
+			if diff := cmp.Diff(inOut.want, got); diff != "" {
+				t.Errorf("Not match operations, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
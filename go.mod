@@ -13,6 +13,7 @@ require (
 	github.com/srvc/fail v3.1.0+incompatible
 	github.com/tcnksm/ghr v0.0.0-20181005104214-1dabd986f323
 	go.uber.org/zap v1.10.0
+	gopkg.in/yaml.v2 v2.2.2
 )
 
 require (
@@ -51,5 +52,4 @@ require (
 	golang.org/x/text v0.3.2 // indirect
 	golang.org/x/tools v0.0.0-20190506145303-2d16b83fe98c // indirect
 	google.golang.org/appengine v1.5.0 // indirect
-	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
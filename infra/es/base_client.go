@@ -7,7 +7,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/moul/http2curl"
 	"github.com/rerost/es-cli/config"
@@ -35,9 +39,33 @@ func (is Indices) String() string {
 
 type Opt struct{}
 type Alias struct{}
+
+const (
+	AliasActionAdd    = "add"
+	AliasActionRemove = "remove"
+	// AliasActionRemoveIndex deletes the index. Alias is not used
+	AliasActionRemoveIndex = "remove_index"
+)
+
+type AliasAction struct {
+	// Type is add, remove or remove_index
+	Type  string
+	Index string
+	Alias string
+	// Options are filter, routing and so on. They are used only when Type is add
+	Options map[string]interface{}
+}
+type Document struct {
+	ID     string          `json:"_id"`
+	Found  bool            `json:"found"`
+	Source json.RawMessage `json:"_source"`
+}
+
 type Task struct {
 	ID       string
 	Complete bool
+	// Total is number of processed documents. It is set when the reindex task is completed
+	Total int
 }
 
 func (t Task) String() string {
@@ -64,7 +92,74 @@ func (c Count) String() string {
 }
 
 type Version struct {
-	Number string `json:"number"`
+	Number       string `json:"number"`
+	Distribution string `json:"distribution"`
+}
+
+const (
+	DistributionElasticsearch = "elasticsearch"
+	DistributionOpenSearch    = "opensearch"
+)
+
+// ServerInfo is the version and the distribution of the cluster. It decides APIs which the cluster accepts.
+type ServerInfo struct {
+	Version      Version
+	Distribution string
+}
+
+func (s ServerInfo) String() string {
+	return s.Distribution + " " + s.Version.Number
+}
+
+func (s ServerInfo) OpenSearch() bool {
+	return s.Distribution == DistributionOpenSearch
+}
+
+// Typed returns whether mappings and documents have types. Elasticsearch 6.x or earlier
+func (s ServerInfo) Typed() bool {
+	return !s.OpenSearch() && s.Version.Major() < 7
+}
+
+// IncludeTypeName returns whether typed mappings are accepted with include_type_name. Elasticsearch 7.x
+func (s ServerInfo) IncludeTypeName() bool {
+	return !s.OpenSearch() && s.Version.Major() == 7
+}
+
+// ComposableTemplate returns whether _index_template is supported.
+func (s ServerInfo) ComposableTemplate() bool {
+	return s.OpenSearch() || s.Version.AtLeast(7, 8)
+}
+
+// PointInTime returns whether _pit with _shard_doc sort is supported. Sliced point in time is supported from 7.15
+// It is false for OpenSearch, because point in time of OpenSearch is another API without _shard_doc.
+func (s ServerInfo) PointInTime(sliced bool) bool {
+	if s.OpenSearch() {
+		return false
+	}
+	if sliced {
+		return s.Version.AtLeast(7, 15)
+	}
+	return s.Version.AtLeast(7, 12)
+}
+
+// mappingKeys are keys of typeless mappings. Other single key is a type name. e.g. {"_doc": {"properties": ...}}
+var mappingKeys = map[string]bool{
+	"properties": true, "dynamic": true, "dynamic_templates": true, "_source": true, "_meta": true,
+	"_routing": true, "_all": true, "_field_names": true, "date_detection": true, "numeric_detection": true,
+	"dynamic_date_formats": true, "runtime": true, "enabled": true,
+}
+
+// MappingType returns the type name when mappings are typed.
+func MappingType(mappings map[string]interface{}) (string, bool) {
+	if len(mappings) != 1 {
+		return "", false
+	}
+	for key, value := range mappings {
+		if _, ok := value.(map[string]interface{}); ok && !mappingKeys[key] {
+			return key, true
+		}
+	}
+	return "", false
 }
 
 type IndexDetail struct {
@@ -85,6 +180,30 @@ func (c Version) String() string {
 	return c.Number
 }
 
+// Major returns major version. e.g. 7 when 7.10.2
+func (c Version) Major() int {
+	major, _ := strconv.Atoi(strings.SplitN(c.Number, ".", 2)[0])
+	return major
+}
+
+// Minor returns minor version. e.g. 10 when 7.10.2
+func (c Version) Minor() int {
+	parts := strings.SplitN(c.Number, ".", 3)
+	if len(parts) < 2 {
+		return 0
+	}
+	minor, _ := strconv.Atoi(parts[1])
+	return minor
+}
+
+// AtLeast returns whether the version is major.minor or later.
+func (c Version) AtLeast(major, minor int) bool {
+	if c.Major() != major {
+		return c.Major() > major
+	}
+	return c.Minor() >= minor
+}
+
 type Pong struct {
 	OK bool
 }
@@ -96,18 +215,113 @@ func (c Pong) String() string {
 	return "Failed"
 }
 
+type SearchHit struct {
+	ID     string                 `json:"_id"`
+	Type   string                 `json:"_type"`
+	Index  string                 `json:"_index"`
+	Source map[string]interface{} `json:"_source"`
+	Sort   []interface{}          `json:"sort,omitempty"`
+}
+
+// HitsTotal is hits.total. It is a number on 6.x, an object on 7.x or later.
+type HitsTotal int64
+
+func (t *HitsTotal) UnmarshalJSON(b []byte) error {
+	var num int64
+	if err := json.Unmarshal(b, &num); err == nil {
+		*t = HitsTotal(num)
+		return nil
+	}
+
+	total := struct {
+		Value int64 `json:"value"`
+	}{}
+	if err := json.Unmarshal(b, &total); err != nil {
+		return fail.Wrap(err)
+	}
+	*t = HitsTotal(total.Value)
+	return nil
+}
+
 type SearchResponse struct {
-	Hits struct {
-		Total int64 `json:"total"`
-		Hits  []struct {
-			ID     string                 `json:"_id"`
-			Type   string                 `json:"_type"`
-			Index  string                 `json:"_index"`
-			Source map[string]interface{} `json:"_source"`
-		} `json:"hits"`
+	ScrollID string `json:"_scroll_id,omitempty"`
+	PitID    string `json:"pit_id,omitempty"`
+	Hits     struct {
+		Total HitsTotal   `json:"total"`
+		Hits  []SearchHit `json:"hits"`
 	} `json:"hits"`
 }
 
+type BulkItemError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type BulkItem struct {
+	Action string
+	Index  string
+	ID     string
+	Status int
+	Error  *BulkItemError
+}
+
+// Failed returns whether the document is rejected. It includes retryable failure.
+func (i BulkItem) Failed() bool {
+	return i.Error != nil || i.Status >= 300
+}
+
+// Retryable returns whether the document is rejected because of too many requests.
+func (i BulkItem) Retryable() bool {
+	return i.Status == http.StatusTooManyRequests
+}
+
+type BulkResult struct {
+	// Items are in the same order as the request
+	Items     []BulkItem
+	Succeeded int
+	// Failed is count of failed documents by error type. Retryable failures are not included.
+	Failed    map[string]int
+	Retryable int
+}
+
+func (r BulkResult) FailedCount() int {
+	count := 0
+	for _, c := range r.Failed {
+		count += c
+	}
+	return count
+}
+
+// Merge adds counts of other. Items are not merged.
+func (r *BulkResult) Merge(other BulkResult) {
+	r.Succeeded += other.Succeeded
+	r.Retryable += other.Retryable
+	if r.Failed == nil {
+		r.Failed = map[string]int{}
+	}
+	for reason, c := range other.Failed {
+		r.Failed[reason] += c
+	}
+}
+
+func (r BulkResult) String() string {
+	result := []string{
+		fmt.Sprintf("Succeeded: %d", r.Succeeded),
+		fmt.Sprintf("Failed: %d", r.FailedCount()),
+	}
+	reasons := make([]string, 0, len(r.Failed))
+	for reason := range r.Failed {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		result = append(result, fmt.Sprintf("  %s: %d", reason, r.Failed[reason]))
+	}
+	result = append(result, fmt.Sprintf("Retryable: %d", r.Retryable))
+
+	return strings.Join(result, "\n")
+}
+
 func (r SearchResponse) String() string {
 	b, err := json.Marshal(r)
 	if err != nil {
@@ -122,36 +336,92 @@ type BaseClient interface {
 	ListIndex(ctx context.Context) (Indices, error)
 	CreateIndex(ctx context.Context, indexName string, mappingJSON string) error
 	CopyIndex(ctx context.Context, srcIndexName string, dstIndexName string) (Task, error)
+	// Reindex starts reindex with the body. e.g. {"source": {"index": "a", "query": ...}, "dest": {"index": "b"}}
+	Reindex(ctx context.Context, reindexJSON string) (Task, error)
 	DeleteIndex(ctx context.Context, indexName string) error
 	CountIndex(ctx context.Context, indexName string) (Count, error)
 	SearchIndex(ctx context.Context, indexName string, query string) (SearchResponse, error)
-	BulkIndex(ctx context.Context, body string) error
+	BulkIndex(ctx context.Context, body string) (BulkResult, error)
+
+	// Point in time. Elasticsearch 7.10 or later
+	OpenPointInTime(ctx context.Context, indexName string, keepAlive string) (string, error)
+	ClosePointInTime(ctx context.Context, pitID string) error
+	// SearchPointInTime searches with "pit" in query. Index is specified by the point in time.
+	SearchPointInTime(ctx context.Context, query string) (SearchResponse, error)
+
+	// Scroll
+	Scroll(ctx context.Context, indexName string, query string, keepAlive string) (SearchResponse, error)
+	ScrollNext(ctx context.Context, scrollID string, keepAlive string) (SearchResponse, error)
+	ClearScroll(ctx context.Context, scrollID string) error
 
 	// Detail
 	DetailIndex(ctx context.Context, indexName string) (IndexDetail, error)
+	// PutMapping adds fields to the mapping. mappingJSON is typeless
+	PutMapping(ctx context.Context, indexName string, mappingJSON string) error
+	PutSettings(ctx context.Context, indexName string, settingsJSON string) error
+	CloseIndex(ctx context.Context, indexName string) error
+	OpenIndex(ctx context.Context, indexName string) error
 
 	// Alias
 	AddAlias(ctx context.Context, aliasName string, indexNames ...string) error
 	RemoveAlias(ctx context.Context, aliasName string, indexNames ...string) error
 	ListAlias(ctx context.Context, aliasName string) (Indices, error)
 	SwapAlias(ctx context.Context, aliasName string, removeIndexName string, addIndexName string) error
+	// UpdateAliases applies all actions atomically
+	UpdateAliases(ctx context.Context, actions []AliasAction) error
+	// ListAliases returns all aliases to their indices
+	ListAliases(ctx context.Context) (map[string][]string, error)
+
+	// Template. Composable templates are used on 7.8 or later, otherwise legacy templates
+	ListTemplates(ctx context.Context) (map[string]json.RawMessage, error)
+	PutTemplate(ctx context.Context, name string, templateJSON string) error
+	DeleteTemplate(ctx context.Context, name string) error
+
+	// Index lifecycle management, or index state management of OpenSearch. Policies are bodies of "policy"
+	ListLifecyclePolicies(ctx context.Context) (map[string]json.RawMessage, error)
+	PutLifecyclePolicy(ctx context.Context, name string, policyJSON string) error
+	DeleteLifecyclePolicy(ctx context.Context, name string) error
+
+	// Ingest pipeline
+	ListPipelines(ctx context.Context) (map[string]json.RawMessage, error)
+	PutPipeline(ctx context.Context, name string, pipelineJSON string) error
+	DeletePipeline(ctx context.Context, name string) error
+
+	// Document
+	// GetDocument returns Found false when the document or the index does not exist
+	GetDocument(ctx context.Context, indexName string, id string) (Document, error)
+	PutDocument(ctx context.Context, indexName string, id string, body string) error
 
 	// Task
 	GetTask(ctx context.Context, taskID string) (Task, error)
 
+	// Version and ServerInfo are requested until it succeeds, and cached by the client
 	Version(ctx context.Context) (Version, error)
+	ServerInfo(ctx context.Context) (ServerInfo, error)
+	// TypeName is the type of mappings and documents of 6.x. It is _doc when it is not configured
+	TypeName() string
 	Ping(ctx context.Context) (Pong, error)
 }
 
+// BaseClientFactory returns a client of the namespace in the configuration file
+type BaseClientFactory func(namespace string) (BaseClient, error)
+
 type baseClientImp struct {
 	Config     config.Config
 	HttpClient *http.Client
+	serverInfo *serverInfoCache
+}
+
+type serverInfoCache struct {
+	mu   sync.Mutex
+	info *ServerInfo
 }
 
 func NewBaseClient(cfg config.Config, httpClient *http.Client) (BaseClient, error) {
 	client := baseClientImp{}
 	client.HttpClient = httpClient
 	client.Config = cfg
+	client.serverInfo = &serverInfoCache{}
 
 	return client, nil
 }
@@ -192,14 +462,12 @@ func (client baseClientImp) httpRequest(ctx context.Context, method string, url
 	if err != nil {
 		return nil, fail.Wrap(err)
 	}
-	responseMap := map[string]interface{}{}
+	defer response.Body.Close()
 
 	responseBody, err := ioutil.ReadAll(response.Body)
-	err = json.Unmarshal(responseBody, &responseMap)
 	if err != nil {
 		return nil, fail.Wrap(err)
 	}
-	defer response.Body.Close()
 
 	// Response log
 	{
@@ -210,17 +478,35 @@ func (client baseClientImp) httpRequest(ctx context.Context, method string, url
 		)
 	}
 
-	if errMsg, ok := responseMap["error"]; ok {
-		return nil, fail.New(fmt.Sprintf("%v", errMsg))
+	responseMap := map[string]interface{}{}
+	err = json.Unmarshal(responseBody, &responseMap)
+	// Security plugins and proxies return errors which are not json. e.g. "Unauthorized"
+	if err != nil && response.StatusCode >= http.StatusBadRequest {
+		errMsg := fmt.Sprintf("%s: %s", response.Status, strings.TrimSpace(string(responseBody)))
+		return nil, fail.Wrap(fail.New(errMsg+authHint(response.StatusCode)), fail.WithCode(response.StatusCode))
+	}
+	if err != nil {
+		return nil, fail.Wrap(err)
 	}
 
-	if errMsg, ok := responseMap["errors"]; ok {
-		return nil, fail.New(fmt.Sprintf("%v", errMsg))
+	if errMsg, ok := responseMap["error"]; ok {
+		return nil, fail.Wrap(fail.New(fmt.Sprintf("%v", errMsg)+authHint(response.StatusCode)), fail.WithCode(response.StatusCode))
 	}
 
 	return responseBody, nil
 }
 
+func authHint(statusCode int) string {
+	switch statusCode {
+	case http.StatusUnauthorized:
+		return " (Authentication failed. Check --user and --pass)"
+	case http.StatusForbidden:
+		return " (The user does not have permission)"
+	default:
+		return ""
+	}
+}
+
 func (client baseClientImp) ListIndex(ctx context.Context) (Indices, error) {
 	indices := Indices{}
 
@@ -244,8 +530,17 @@ func (client baseClientImp) ListIndex(ctx context.Context) (Indices, error) {
 	return indices, nil
 }
 func (client baseClientImp) CreateIndex(ctx context.Context, indexName string, mappingJSON string) error {
+	info, err := client.ServerInfo(ctx)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+	mappingJSON, typed, err := client.compatibleDetail(info, mappingJSON)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
 	params := map[string]string{}
-	if client.Config.SetIncludeTypeName {
+	if info.IncludeTypeName() && (typed || client.Config.SetIncludeTypeName) {
 		params["include_type_name"] = "true"
 	}
 
@@ -268,6 +563,37 @@ func (client baseClientImp) CreateIndex(ctx context.Context, indexName string, m
 
 	return nil
 }
+
+// compatibleDetail wraps typeless mappings by the type for typed clusters, and unwraps typed mappings for clusters which reject types.
+// typed is true when the returned detail has typed mappings.
+func (client baseClientImp) compatibleDetail(info ServerInfo, detailJSON string) (string, bool, error) {
+	detail := map[string]interface{}{}
+	// Invalid json is sent as it is, so that Elasticsearch returns the error
+	if json.Unmarshal([]byte(detailJSON), &detail) != nil {
+		return detailJSON, false, nil
+	}
+	mappings, ok := detail["mappings"].(map[string]interface{})
+	if !ok || len(mappings) == 0 {
+		return detailJSON, false, nil
+	}
+
+	typeName, typed := MappingType(mappings)
+	switch {
+	case info.Typed() && !typed:
+		typeName = client.TypeName()
+		detail["mappings"] = map[string]interface{}{typeName: mappings}
+	case !info.Typed() && !info.IncludeTypeName() && typed:
+		detail["mappings"] = mappings[typeName]
+	default:
+		return detailJSON, typed, nil
+	}
+
+	b, err := json.Marshal(detail)
+	if err != nil {
+		return "", false, fail.Wrap(err)
+	}
+	return string(b), info.Typed(), nil
+}
 func (client baseClientImp) CopyIndex(ctx context.Context, srcIndexName string, dstIndexName string) (Task, error) {
 	reindexJSON := fmt.Sprintf(`
 {
@@ -279,6 +605,10 @@ func (client baseClientImp) CopyIndex(ctx context.Context, srcIndexName string,
 	}
 }
 	`, srcIndexName, dstIndexName)
+	task, err := client.Reindex(ctx, reindexJSON)
+	return task, fail.Wrap(err)
+}
+func (client baseClientImp) Reindex(ctx context.Context, reindexJSON string) (Task, error) {
 	responseBody, err := client.httpRequest(ctx, http.MethodPost, client.reindexURL(), reindexJSON, "application/json", map[string]string{"wait_for_completion": "false"})
 	if err != nil {
 		return Task{}, fail.Wrap(err)
@@ -344,20 +674,143 @@ func (client baseClientImp) SearchIndex(ctx context.Context, indexName string, q
 
 	return searchResponse, nil
 }
-func (client baseClientImp) BulkIndex(ctx context.Context, body string) error {
+func (client baseClientImp) BulkIndex(ctx context.Context, body string) (BulkResult, error) {
 	responseBody, err := client.httpRequest(ctx, http.MethodPost, client.bulkURL(), body, "application/x-ndjson", nil)
 	if err != nil {
-		return fail.Wrap(err)
+		return BulkResult{}, fail.Wrap(err)
+	}
+
+	bulkResponse := struct {
+		Items []map[string]struct {
+			Index  string         `json:"_index"`
+			ID     string         `json:"_id"`
+			Status int            `json:"status"`
+			Error  *BulkItemError `json:"error"`
+		} `json:"items"`
+	}{}
+	err = json.Unmarshal(responseBody, &bulkResponse)
+	if err != nil {
+		return BulkResult{}, fail.Wrap(err)
+	}
+
+	result := BulkResult{
+		Items:  make([]BulkItem, 0, len(bulkResponse.Items)),
+		Failed: map[string]int{},
+	}
+	for _, actionItem := range bulkResponse.Items {
+		// Each item has only one action. e.g. {"index": {...}}
+		for action, item := range actionItem {
+			bulkItem := BulkItem{
+				Action: action,
+				Index:  item.Index,
+				ID:     item.ID,
+				Status: item.Status,
+				Error:  item.Error,
+			}
+			result.Items = append(result.Items, bulkItem)
+
+			switch {
+			case bulkItem.Retryable():
+				result.Retryable++
+			case bulkItem.Failed():
+				reason := "unknown"
+				if bulkItem.Error != nil {
+					reason = bulkItem.Error.Type
+				}
+				result.Failed[reason]++
+			default:
+				result.Succeeded++
+			}
+		}
 	}
 
-	responseMap := map[string]interface{}{}
+	return result, nil
+}
 
+func (client baseClientImp) OpenPointInTime(ctx context.Context, indexName string, keepAlive string) (string, error) {
+	responseBody, err := client.httpRequest(ctx, http.MethodPost, client.pitURL(indexName), "", "", map[string]string{"keep_alive": keepAlive})
+	if err != nil {
+		return "", fail.Wrap(err)
+	}
+
+	responseMap := map[string]interface{}{}
 	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return "", fail.Wrap(err)
+	}
+
+	if _, ok := responseMap["id"].(string); !ok {
+		return "", fail.New(fmt.Sprintf("Not found point in time id: %v", string(responseBody)))
+	}
+
+	return responseMap["id"].(string), nil
+}
+func (client baseClientImp) ClosePointInTime(ctx context.Context, pitID string) error {
+	body, err := json.Marshal(map[string]string{"id": pitID})
 	if err != nil {
 		return fail.Wrap(err)
 	}
 
-	return nil
+	_, err = client.httpRequest(ctx, http.MethodDelete, client.closePitURL(), string(body), "application/json", nil)
+	return fail.Wrap(err)
+}
+func (client baseClientImp) SearchPointInTime(ctx context.Context, query string) (SearchResponse, error) {
+	responseBody, err := client.httpRequest(ctx, http.MethodPost, client.baseURL()+"/_search", query, "application/json", nil)
+	if err != nil {
+		return SearchResponse{}, fail.Wrap(err)
+	}
+
+	searchResponse := SearchResponse{}
+	err = json.Unmarshal(responseBody, &searchResponse)
+	if err != nil {
+		return SearchResponse{}, fail.Wrap(err)
+	}
+
+	return searchResponse, nil
+}
+
+// Scroll
+func (client baseClientImp) Scroll(ctx context.Context, indexName string, query string, keepAlive string) (SearchResponse, error) {
+	responseBody, err := client.httpRequest(ctx, http.MethodPost, client.searchURL(indexName), query, "application/json", map[string]string{"scroll": keepAlive})
+	if err != nil {
+		return SearchResponse{}, fail.Wrap(err)
+	}
+
+	searchResponse := SearchResponse{}
+	err = json.Unmarshal(responseBody, &searchResponse)
+	if err != nil {
+		return SearchResponse{}, fail.Wrap(err)
+	}
+
+	return searchResponse, nil
+}
+func (client baseClientImp) ScrollNext(ctx context.Context, scrollID string, keepAlive string) (SearchResponse, error) {
+	body, err := json.Marshal(map[string]string{"scroll": keepAlive, "scroll_id": scrollID})
+	if err != nil {
+		return SearchResponse{}, fail.Wrap(err)
+	}
+
+	responseBody, err := client.httpRequest(ctx, http.MethodPost, client.scrollURL(), string(body), "application/json", nil)
+	if err != nil {
+		return SearchResponse{}, fail.Wrap(err)
+	}
+
+	searchResponse := SearchResponse{}
+	err = json.Unmarshal(responseBody, &searchResponse)
+	if err != nil {
+		return SearchResponse{}, fail.Wrap(err)
+	}
+
+	return searchResponse, nil
+}
+func (client baseClientImp) ClearScroll(ctx context.Context, scrollID string) error {
+	body, err := json.Marshal(map[string][]string{"scroll_id": {scrollID}})
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	_, err = client.httpRequest(ctx, http.MethodDelete, client.scrollURL(), string(body), "application/json", nil)
+	return fail.Wrap(err)
 }
 
 func (client baseClientImp) DetailIndex(ctx context.Context, indexName string) (IndexDetail, error) {
@@ -392,6 +845,79 @@ func (client baseClientImp) DetailIndex(ctx context.Context, indexName string) (
 	return indexDetail, nil
 }
 
+func (client baseClientImp) PutMapping(ctx context.Context, indexName string, mappingJSON string) error {
+	info, err := client.ServerInfo(ctx)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	// Type is required before 7, and it is allowed with include_type_name on 7
+	requestURL := client.rawIndexURL(indexName) + "/_mapping"
+	var params map[string]string
+	switch {
+	case info.Typed():
+		requestURL = client.mappingURL(indexName)
+	case info.IncludeTypeName() && client.Config.SetIncludeTypeName:
+		requestURL = client.mappingURL(indexName)
+		params = map[string]string{"include_type_name": "true"}
+	}
+
+	responseBody, err := client.httpRequest(ctx, http.MethodPut, requestURL, mappingJSON, "application/json", params)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseMap := map[string]interface{}{}
+	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	return nil
+}
+func (client baseClientImp) PutSettings(ctx context.Context, indexName string, settingsJSON string) error {
+	responseBody, err := client.httpRequest(ctx, http.MethodPut, client.settingsURL(indexName), settingsJSON, "application/json", nil)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseMap := map[string]interface{}{}
+	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	return nil
+}
+func (client baseClientImp) CloseIndex(ctx context.Context, indexName string) error {
+	responseBody, err := client.httpRequest(ctx, http.MethodPost, client.closeURL(indexName), "", "", nil)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseMap := map[string]interface{}{}
+	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	return nil
+}
+func (client baseClientImp) OpenIndex(ctx context.Context, indexName string) error {
+	responseBody, err := client.httpRequest(ctx, http.MethodPost, client.openURL(indexName), "", "", nil)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseMap := map[string]interface{}{}
+	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	return nil
+}
+
 // Alias
 func (client baseClientImp) AddAlias(ctx context.Context, aliasName string, indexNames ...string) error {
 	actions := []string{}
@@ -493,6 +1019,65 @@ func (client baseClientImp) SwapAlias(ctx context.Context, aliasName string, rem
 
 	return nil
 }
+func (client baseClientImp) UpdateAliases(ctx context.Context, actions []AliasAction) error {
+	body := map[string][]map[string]map[string]interface{}{"actions": {}}
+	for _, action := range actions {
+		params := map[string]interface{}{}
+		if action.Type == AliasActionAdd {
+			for key, value := range action.Options {
+				params[key] = value
+			}
+		}
+		params["index"] = action.Index
+		if action.Type != AliasActionRemoveIndex {
+			params["alias"] = action.Alias
+		}
+		body["actions"] = append(body["actions"], map[string]map[string]interface{}{action.Type: params})
+	}
+	updateAliasesJSON, err := json.Marshal(body)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseBody, err := client.httpRequest(ctx, http.MethodPost, client.aliasURL(), string(updateAliasesJSON), "application/json", nil)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseMap := map[string]interface{}{}
+
+	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	return nil
+}
+func (client baseClientImp) ListAliases(ctx context.Context) (map[string][]string, error) {
+	responseBody, err := client.httpRequest(ctx, http.MethodGet, client.listIndexURL(), "", "", nil)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	responseMap := map[string]struct {
+		Aliases map[string]interface{} `json:"aliases"`
+	}{}
+	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	aliases := map[string][]string{}
+	for indexName, index := range responseMap {
+		for aliasName := range index.Aliases {
+			aliases[aliasName] = append(aliases[aliasName], indexName)
+		}
+	}
+	for aliasName := range aliases {
+		sort.Strings(aliases[aliasName])
+	}
+	return aliases, nil
+}
 func (client baseClientImp) ListAlias(ctx context.Context, aliasName string) (Indices, error) {
 	indices := Indices{}
 	responseBody, err := client.httpRequest(ctx, http.MethodGet, client.rawIndexURL(aliasName), "", "", nil)
@@ -534,38 +1119,386 @@ func (client baseClientImp) GetTask(ctx context.Context, taskID string) (Task, e
 		return Task{}, fail.New(fmt.Sprintf("Failed to extract completed from resposne"))
 	}
 
-	return Task{Complete: responseMap["completed"].(bool)}, nil
+	task := Task{ID: taskID, Complete: responseMap["completed"].(bool)}
+	if response, ok := responseMap["response"].(map[string]interface{}); ok {
+		if total, ok := response["total"].(float64); ok {
+			task.Total = int(total)
+		}
+		if failures, ok := response["failures"].([]interface{}); ok && len(failures) > 0 {
+			b, _ := json.Marshal(failures[0])
+			return task, fail.New(fmt.Sprintf("Task has %d failures: %s", len(failures), string(b)))
+		}
+	}
+	return task, nil
+}
+
+// Template
+func (client baseClientImp) ListTemplates(ctx context.Context) (map[string]json.RawMessage, error) {
+	composable, err := client.composableTemplate(ctx)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	responseBody, err := client.httpRequest(ctx, http.MethodGet, client.templateURL(composable, ""), "", "", nil)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	if !composable {
+		templates := map[string]json.RawMessage{}
+		err = json.Unmarshal(responseBody, &templates)
+		return templates, fail.Wrap(err)
+	}
+
+	response := struct {
+		IndexTemplates []struct {
+			Name          string          `json:"name"`
+			IndexTemplate json.RawMessage `json:"index_template"`
+		} `json:"index_templates"`
+	}{}
+	err = json.Unmarshal(responseBody, &response)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	templates := make(map[string]json.RawMessage, len(response.IndexTemplates))
+	for _, template := range response.IndexTemplates {
+		templates[template.Name] = template.IndexTemplate
+	}
+	return templates, nil
+}
+func (client baseClientImp) PutTemplate(ctx context.Context, name string, templateJSON string) error {
+	composable, err := client.composableTemplate(ctx)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseBody, err := client.httpRequest(ctx, http.MethodPut, client.templateURL(composable, name), templateJSON, "application/json", nil)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseMap := map[string]interface{}{}
+	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	return nil
+}
+func (client baseClientImp) DeleteTemplate(ctx context.Context, name string) error {
+	composable, err := client.composableTemplate(ctx)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseBody, err := client.httpRequest(ctx, http.MethodDelete, client.templateURL(composable, name), "", "", nil)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseMap := map[string]interface{}{}
+	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	return nil
+}
+
+// composableTemplate returns whether _index_template is supported
+func (client baseClientImp) composableTemplate(ctx context.Context) (bool, error) {
+	info, err := client.ServerInfo(ctx)
+	if err != nil {
+		return false, fail.Wrap(err)
+	}
+	return info.ComposableTemplate(), nil
+}
+
+// Index lifecycle management. OpenSearch uses index state management instead
+func (client baseClientImp) ListLifecyclePolicies(ctx context.Context) (map[string]json.RawMessage, error) {
+	info, err := client.ServerInfo(ctx)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	if info.OpenSearch() {
+		return client.listStateManagementPolicies(ctx)
+	}
+
+	responseBody, err := client.httpRequest(ctx, http.MethodGet, client.lifecyclePolicyURL(false, ""), "", "", nil)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	responseMap := map[string]struct {
+		Policy json.RawMessage `json:"policy"`
+	}{}
+	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	policies := make(map[string]json.RawMessage, len(responseMap))
+	for name, policy := range responseMap {
+		policies[name] = policy.Policy
+	}
+	return policies, nil
+}
+func (client baseClientImp) PutLifecyclePolicy(ctx context.Context, name string, policyJSON string) error {
+	info, err := client.ServerInfo(ctx)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	var params map[string]string
+	if info.OpenSearch() {
+		// Index state management requires the sequence number to update the policy
+		params, err = client.stateManagementPolicyVersion(ctx, name)
+		if err != nil {
+			return fail.Wrap(err)
+		}
+	}
+
+	body := fmt.Sprintf(`{"policy": %s}`, policyJSON)
+	responseBody, err := client.httpRequest(ctx, http.MethodPut, client.lifecyclePolicyURL(info.OpenSearch(), name), body, "application/json", params)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseMap := map[string]interface{}{}
+	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	return nil
+}
+func (client baseClientImp) DeleteLifecyclePolicy(ctx context.Context, name string) error {
+	info, err := client.ServerInfo(ctx)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseBody, err := client.httpRequest(ctx, http.MethodDelete, client.lifecyclePolicyURL(info.OpenSearch(), name), "", "", nil)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseMap := map[string]interface{}{}
+	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	return nil
+}
+
+// stateManagementServerFields are fields of index state management policies which are set by OpenSearch.
+var stateManagementServerFields = []string{"policy_id", "last_updated_time", "schema_version"}
+
+func (client baseClientImp) listStateManagementPolicies(ctx context.Context) (map[string]json.RawMessage, error) {
+	responseBody, err := client.httpRequest(ctx, http.MethodGet, client.lifecyclePolicyURL(true, ""), "", "", map[string]string{"size": "10000"})
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	response := struct {
+		Policies []struct {
+			ID     string                 `json:"_id"`
+			Policy map[string]interface{} `json:"policy"`
+		} `json:"policies"`
+	}{}
+	err = json.Unmarshal(responseBody, &response)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	policies := make(map[string]json.RawMessage, len(response.Policies))
+	for _, policy := range response.Policies {
+		for _, key := range stateManagementServerFields {
+			delete(policy.Policy, key)
+		}
+		b, err := json.Marshal(policy.Policy)
+		if err != nil {
+			return nil, fail.Wrap(err)
+		}
+		policies[policy.ID] = b
+	}
+	return policies, nil
+}
+
+// stateManagementPolicyVersion returns if_seq_no and if_primary_term of the policy. It returns nil when the policy does not exist.
+func (client baseClientImp) stateManagementPolicyVersion(ctx context.Context, name string) (map[string]string, error) {
+	responseBody, err := client.httpRequest(ctx, http.MethodGet, client.lifecyclePolicyURL(true, name), "", "", nil)
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	response := struct {
+		SeqNo       int64 `json:"_seq_no"`
+		PrimaryTerm int64 `json:"_primary_term"`
+	}{}
+	err = json.Unmarshal(responseBody, &response)
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+	return map[string]string{
+		"if_seq_no":       strconv.FormatInt(response.SeqNo, 10),
+		"if_primary_term": strconv.FormatInt(response.PrimaryTerm, 10),
+	}, nil
+}
+
+// Ingest pipeline
+func (client baseClientImp) ListPipelines(ctx context.Context) (map[string]json.RawMessage, error) {
+	responseBody, err := client.httpRequest(ctx, http.MethodGet, client.pipelineURL(""), "", "", nil)
+	// Elasticsearch returns 404 when there is no pipeline
+	if IsNotFound(err) {
+		return map[string]json.RawMessage{}, nil
+	}
+	if err != nil {
+		return nil, fail.Wrap(err)
+	}
+
+	pipelines := map[string]json.RawMessage{}
+	err = json.Unmarshal(responseBody, &pipelines)
+	return pipelines, fail.Wrap(err)
+}
+func (client baseClientImp) PutPipeline(ctx context.Context, name string, pipelineJSON string) error {
+	responseBody, err := client.httpRequest(ctx, http.MethodPut, client.pipelineURL(name), pipelineJSON, "application/json", nil)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseMap := map[string]interface{}{}
+	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	return nil
+}
+func (client baseClientImp) DeletePipeline(ctx context.Context, name string) error {
+	responseBody, err := client.httpRequest(ctx, http.MethodDelete, client.pipelineURL(name), "", "", nil)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseMap := map[string]interface{}{}
+	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	return nil
+}
+
+// Document
+func (client baseClientImp) GetDocument(ctx context.Context, indexName string, id string) (Document, error) {
+	info, err := client.ServerInfo(ctx)
+	if err != nil {
+		return Document{}, fail.Wrap(err)
+	}
+
+	responseBody, err := client.httpRequest(ctx, http.MethodGet, client.documentURL(info, indexName, id), "", "", nil)
+	if IsNotFound(err) {
+		return Document{ID: id}, nil
+	}
+	if err != nil {
+		return Document{}, fail.Wrap(err)
+	}
+
+	document := Document{}
+	err = json.Unmarshal(responseBody, &document)
+	if err != nil {
+		return Document{}, fail.Wrap(err)
+	}
+	return document, nil
+}
+func (client baseClientImp) PutDocument(ctx context.Context, indexName string, id string, body string) error {
+	info, err := client.ServerInfo(ctx)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseBody, err := client.httpRequest(ctx, http.MethodPut, client.documentURL(info, indexName, id), body, "application/json", nil)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	responseMap := map[string]interface{}{}
+	err = json.Unmarshal(responseBody, &responseMap)
+	if err != nil {
+		return fail.Wrap(err)
+	}
+
+	return nil
 }
 
 // Util
 func (client baseClientImp) Version(ctx context.Context) (Version, error) {
+	info, err := client.ServerInfo(ctx)
+	return info.Version, fail.Wrap(err)
+}
+func (client baseClientImp) ServerInfo(ctx context.Context) (ServerInfo, error) {
+	client.serverInfo.mu.Lock()
+	defer client.serverInfo.mu.Unlock()
+	if client.serverInfo.info != nil {
+		return *client.serverInfo.info, nil
+	}
+
+	// Errors are not cached, because they may be temporary. e.g. the cluster is starting
+	info, err := client.fetchServerInfo(ctx)
+	if err != nil {
+		return ServerInfo{}, fail.Wrap(err)
+	}
+	client.serverInfo.info = &info
+	return info, nil
+}
+func (client baseClientImp) TypeName() string {
+	if client.Config.Type == "" {
+		return "_doc"
+	}
+	return client.Config.Type
+}
+func (client baseClientImp) fetchServerInfo(ctx context.Context) (ServerInfo, error) {
 	responseBody, err := client.httpRequest(ctx, http.MethodGet, client.baseURL(), "", "application/json", nil)
 	if err != nil {
-		return Version{}, fail.Wrap(err)
+		return ServerInfo{}, fail.Wrap(err)
 	}
 
 	responseMap := map[string]interface{}{}
 
 	err = json.Unmarshal(responseBody, &responseMap)
 	if err != nil {
-		return Version{}, fail.Wrap(err)
+		return ServerInfo{}, fail.Wrap(err)
 	}
 
 	jsonVersion, err := json.Marshal(responseMap["version"])
 	if err != nil {
-		return Version{}, fail.Wrap(err)
+		return ServerInfo{}, fail.Wrap(err)
 	}
 	version := Version{}
 	err = json.Unmarshal(jsonVersion, &version)
 
 	if err != nil {
-		return Version{}, fail.Wrap(err)
+		return ServerInfo{}, fail.Wrap(err)
 	}
 	if version.Number == "" {
-		return Version{}, fail.New(fmt.Sprintf("Invalid response is returned %v", string(responseBody)))
+		return ServerInfo{}, fail.New(fmt.Sprintf("Invalid response is returned %v", string(responseBody)))
 	}
 
-	return version, nil
+	distribution := version.Distribution
+	// OpenSearch in compatibility mode reports version 7.10.2 without the distribution
+	if tagline, _ := responseMap["tagline"].(string); strings.Contains(tagline, "OpenSearch") {
+		distribution = DistributionOpenSearch
+	}
+	if distribution == "" {
+		distribution = DistributionElasticsearch
+	}
+	zap.L().Debug("server", zap.String("version", version.Number), zap.String("distribution", distribution))
+	return ServerInfo{Version: version, Distribution: distribution}, nil
 }
 func (client baseClientImp) Ping(ctx context.Context) (Pong, error) {
 	request, err := http.NewRequest(http.MethodGet, client.baseURL(), bytes.NewBufferString(""))
@@ -597,9 +1530,6 @@ func (client baseClientImp) baseURL() string {
 func (client baseClientImp) listIndexURL() string {
 	return client.baseURL() + "/_aliases"
 }
-func (client baseClientImp) indexURL(indexName string) string {
-	return client.baseURL() + "/" + indexName + "/" + client.Config.Type
-}
 func (client baseClientImp) rawIndexURL(indexName string) string {
 	return client.baseURL() + "/" + indexName
 }
@@ -613,17 +1543,68 @@ func (client baseClientImp) taskURL(taskID string) string {
 	return client.tasksURL() + "/" + taskID
 }
 func (client baseClientImp) mappingURL(indexOrAliasName string) string {
-	return client.baseURL() + "/" + indexOrAliasName + "/" + "_mapping" + "/" + client.Config.Type
+	return client.baseURL() + "/" + indexOrAliasName + "/" + "_mapping" + "/" + client.TypeName()
+}
+func (client baseClientImp) settingsURL(indexName string) string {
+	return client.rawIndexURL(indexName) + "/_settings"
+}
+func (client baseClientImp) closeURL(indexName string) string {
+	return client.rawIndexURL(indexName) + "/_close"
+}
+func (client baseClientImp) openURL(indexName string) string {
+	return client.rawIndexURL(indexName) + "/_open"
+}
+func (client baseClientImp) documentURL(info ServerInfo, indexName string, id string) string {
+	typeName := "_doc"
+	if info.Typed() {
+		typeName = client.TypeName()
+	}
+	return client.rawIndexURL(indexName) + "/" + typeName + "/" + url.PathEscape(id)
+}
+func (client baseClientImp) templateURL(composable bool, name string) string {
+	path := "/_template"
+	if composable {
+		path = "/_index_template"
+	}
+	if name == "" {
+		return client.baseURL() + path
+	}
+	return client.baseURL() + path + "/" + name
+}
+func (client baseClientImp) lifecyclePolicyURL(stateManagement bool, name string) string {
+	path := "/_ilm/policy"
+	if stateManagement {
+		path = "/_plugins/_ism/policies"
+	}
+	if name == "" {
+		return client.baseURL() + path
+	}
+	return client.baseURL() + path + "/" + name
+}
+func (client baseClientImp) pipelineURL(name string) string {
+	if name == "" {
+		return client.baseURL() + "/_ingest/pipeline"
+	}
+	return client.baseURL() + "/_ingest/pipeline/" + name
 }
 func (client baseClientImp) aliasURL() string {
 	return client.baseURL() + "/_aliases"
 }
 func (client baseClientImp) countURL(indexName string) string {
-	return client.indexURL(indexName) + "/_count"
+	return client.rawIndexURL(indexName) + "/_count"
 }
 func (client baseClientImp) searchURL(indexName string) string {
 	return client.baseURL() + "/" + indexName + "/_search"
 }
+func (client baseClientImp) pitURL(indexName string) string {
+	return client.baseURL() + "/" + indexName + "/_pit"
+}
+func (client baseClientImp) closePitURL() string {
+	return client.baseURL() + "/_pit"
+}
+func (client baseClientImp) scrollURL() string {
+	return client.baseURL() + "/_search/scroll"
+}
 func (client baseClientImp) bulkURL() string {
 	return client.baseURL() + "/_bulk"
 }
@@ -631,6 +1612,18 @@ func (client baseClientImp) detailURL(indexName string) string {
 	return client.baseURL() + "/" + indexName
 }
 
+// IsNotFound returns whether the resource such as an index is not found.
+func IsNotFound(err error) bool {
+	e := fail.Unwrap(err)
+	return e != nil && e.Code == http.StatusNotFound
+}
+
+// IsTooManyRequests returns whether Elasticsearch rejected the request because of load.
+func IsTooManyRequests(err error) bool {
+	e := fail.Unwrap(err)
+	return e != nil && e.Code == http.StatusTooManyRequests
+}
+
 func addParams(req *http.Request, params map[string]string) *http.Request {
 	q := req.URL.Query()
 	for k, v := range params {
@@ -2,9 +2,12 @@ package es_test
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -62,6 +65,7 @@ func TestListIndex(t *testing.T) {
 	}
 
 	for _, inOut := range inOutPairs {
+		inOut := inOut
 		t.Run(inOut.name, func(t *testing.T) {
 			t.Parallel()
 			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -90,3 +94,467 @@ func TestListIndex(t *testing.T) {
 		})
 	}
 }
+
+func TestBulkIndex(t *testing.T) {
+	t.Parallel()
+	type InOutPairs struct {
+		name   string
+		out    es.BulkResult
+		esResp string
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name: "when all documents are indexed",
+			out: es.BulkResult{
+				Items: []es.BulkItem{
+					{Action: "index", Index: "test", ID: "1", Status: 201},
+				},
+				Succeeded: 1,
+				Failed:    map[string]int{},
+			},
+			esResp: `
+{
+	"took": 1,
+	"errors": false,
+	"items": [
+		{"index": {"_index": "test", "_id": "1", "status": 201}}
+	]
+}`,
+		},
+		{
+			name: "when some documents are rejected",
+			out: es.BulkResult{
+				Items: []es.BulkItem{
+					{Action: "index", Index: "test", ID: "1", Status: 201},
+					{Action: "index", Index: "test", ID: "2", Status: 400, Error: &es.BulkItemError{Type: "mapper_parsing_exception", Reason: "failed to parse"}},
+					{Action: "create", Index: "test", ID: "3", Status: 429, Error: &es.BulkItemError{Type: "es_rejected_execution_exception", Reason: "rejected execution"}},
+				},
+				Succeeded: 1,
+				Failed:    map[string]int{"mapper_parsing_exception": 1},
+				Retryable: 1,
+			},
+			esResp: `
+{
+	"took": 1,
+	"errors": true,
+	"items": [
+		{"index": {"_index": "test", "_id": "1", "status": 201}},
+		{"index": {"_index": "test", "_id": "2", "status": 400, "error": {"type": "mapper_parsing_exception", "reason": "failed to parse"}}},
+		{"create": {"_index": "test", "_id": "3", "status": 429, "error": {"type": "es_rejected_execution_exception", "reason": "rejected execution"}}}
+	]
+}`,
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintln(w, inOut.esResp)
+			}))
+			defer ts.Close()
+
+			ctx := context.Background()
+			cfg := config.Config{
+				Host: ts.URL,
+				Type: "_doc",
+			}
+			baseClient, _ := es.NewBaseClient(cfg, ts.Client())
+			result, err := baseClient.BulkIndex(ctx, "")
+			if err != nil {
+				t.Errorf("Failed to bulk: %v", err)
+			}
+
+			if diff := cmp.Diff(inOut.out, result); diff != "" {
+				t.Errorf("Not mutch result, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestCompatibility(t *testing.T) {
+	t.Parallel()
+
+	typeless := `{"mappings":{"properties":{"n":{"type":"long"}}}}`
+	typed := `{"mappings":{"_doc":{"properties":{"n":{"type":"long"}}}}}`
+
+	type request struct {
+		method string
+		path   string
+		query  string
+		body   string
+	}
+	type InOutPairs struct {
+		name string
+		// root is the response of GET /
+		root string
+		info es.ServerInfo
+		want []request
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name: "Elasticsearch 6.x",
+			root: `{"version": {"number": "6.8.0"}}`,
+			info: es.ServerInfo{Version: es.Version{Number: "6.8.0"}, Distribution: es.DistributionElasticsearch},
+			want: []request{
+				{method: "PUT", path: "/test", body: typed},
+				{method: "PUT", path: "/test", body: typed},
+				{method: "PUT", path: "/test/_mapping/_doc", body: `{"properties":{}}`},
+				{method: "PUT", path: "/test/_doc/1", body: `{}`},
+				{method: "GET", path: "/test/_count"},
+				{method: "DELETE", path: "/_ilm/policy/test"},
+			},
+		},
+		{
+			name: "Elasticsearch 7.x",
+			root: `{"version": {"number": "7.17.0"}}`,
+			info: es.ServerInfo{Version: es.Version{Number: "7.17.0"}, Distribution: es.DistributionElasticsearch},
+			want: []request{
+				{method: "PUT", path: "/test", body: typeless},
+				{method: "PUT", path: "/test", query: "include_type_name=true", body: typed},
+				{method: "PUT", path: "/test/_mapping", body: `{"properties":{}}`},
+				{method: "PUT", path: "/test/_doc/1", body: `{}`},
+				{method: "GET", path: "/test/_count"},
+				{method: "DELETE", path: "/_ilm/policy/test"},
+			},
+		},
+		{
+			name: "Elasticsearch 8.x",
+			root: `{"version": {"number": "8.11.0"}}`,
+			info: es.ServerInfo{Version: es.Version{Number: "8.11.0"}, Distribution: es.DistributionElasticsearch},
+			want: []request{
+				{method: "PUT", path: "/test", body: typeless},
+				{method: "PUT", path: "/test", body: typeless},
+				{method: "PUT", path: "/test/_mapping", body: `{"properties":{}}`},
+				{method: "PUT", path: "/test/_doc/1", body: `{}`},
+				{method: "GET", path: "/test/_count"},
+				{method: "DELETE", path: "/_ilm/policy/test"},
+			},
+		},
+		{
+			name: "OpenSearch",
+			root: `{"version": {"number": "2.11.0", "distribution": "opensearch"}}`,
+			info: es.ServerInfo{Version: es.Version{Number: "2.11.0", Distribution: "opensearch"}, Distribution: es.DistributionOpenSearch},
+			want: []request{
+				{method: "PUT", path: "/test", body: typeless},
+				{method: "PUT", path: "/test", body: typeless},
+				{method: "PUT", path: "/test/_mapping", body: `{"properties":{}}`},
+				{method: "PUT", path: "/test/_doc/1", body: `{}`},
+				{method: "GET", path: "/test/_count"},
+				{method: "DELETE", path: "/_plugins/_ism/policies/test"},
+			},
+		},
+		{
+			name: "OpenSearch compatibility mode",
+			root: `{"version": {"number": "7.10.2"}, "tagline": "The OpenSearch Project: https://opensearch.org/"}`,
+			info: es.ServerInfo{Version: es.Version{Number: "7.10.2"}, Distribution: es.DistributionOpenSearch},
+			want: []request{
+				{method: "PUT", path: "/test", body: typeless},
+				{method: "PUT", path: "/test", body: typeless},
+				{method: "PUT", path: "/test/_mapping", body: `{"properties":{}}`},
+				{method: "PUT", path: "/test/_doc/1", body: `{}`},
+				{method: "GET", path: "/test/_count"},
+				{method: "DELETE", path: "/_plugins/_ism/policies/test"},
+			},
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			var mu sync.Mutex
+			versionRequests := 0
+			got := []request{}
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.URL.Path == "/" {
+					versionRequests++
+					fmt.Fprintln(w, inOut.root)
+					return
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				got = append(got, request{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery, body: string(body)})
+				fmt.Fprintln(w, `{"acknowledged": true, "count": 0}`)
+			}))
+			defer ts.Close()
+
+			ctx := context.Background()
+			cfg := config.Config{
+				Host: ts.URL,
+				Type: "_doc",
+			}
+			baseClient, _ := es.NewBaseClient(cfg, ts.Client())
+
+			info, err := baseClient.ServerInfo(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(inOut.info, info); diff != "" {
+				t.Errorf("Not mutch server info, diff(-want, +got) %s", diff)
+			}
+
+			for _, f := range []func() error{
+				func() error { return baseClient.CreateIndex(ctx, "test", typeless) },
+				func() error { return baseClient.CreateIndex(ctx, "test", typed) },
+				func() error { return baseClient.PutMapping(ctx, "test", `{"properties":{}}`) },
+				func() error { return baseClient.PutDocument(ctx, "test", "1", `{}`) },
+				func() error { _, err := baseClient.CountIndex(ctx, "test"); return err },
+				func() error { return baseClient.DeleteLifecyclePolicy(ctx, "test") },
+			} {
+				if err := f(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if diff := cmp.Diff(inOut.want, got, cmp.AllowUnexported(request{})); diff != "" {
+				t.Errorf("Not mutch requests, diff(-want, +got) %s", diff)
+			}
+			if versionRequests != 1 {
+				t.Errorf("Version is requested %d times", versionRequests)
+			}
+		})
+	}
+}
+
+func TestPutMappingTypeName(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name     string
+		typeName string
+		want     string
+	}
+	inOutPairs := []InOutPairs{
+		{name: "Default", typeName: "", want: "/test/_mapping/_doc"},
+		{name: "Configured", typeName: "doc", want: "/test/_mapping/doc"},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			var mu sync.Mutex
+			paths := []string{}
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.URL.Path == "/" {
+					fmt.Fprintln(w, `{"version": {"number": "6.8.0"}}`)
+					return
+				}
+				paths = append(paths, r.URL.Path)
+				fmt.Fprintln(w, `{"acknowledged": true}`)
+			}))
+			defer ts.Close()
+
+			baseClient, _ := es.NewBaseClient(config.Config{Host: ts.URL, Type: inOut.typeName}, ts.Client())
+			err := baseClient.PutMapping(context.Background(), "test", `{"properties":{}}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff([]string{inOut.want}, paths); diff != "" {
+				t.Errorf("Not mutch requests, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestStateManagementPolicy(t *testing.T) {
+	t.Parallel()
+
+	type request struct {
+		method string
+		path   string
+		query  string
+	}
+	type InOutPairs struct {
+		name string
+		// policy is the response of GET of the policy. The policy does not exist when it is empty
+		policy string
+		want   []request
+	}
+	inOutPairs := []InOutPairs{
+		{
+			name:   "Update",
+			policy: `{"_id": "logs", "_seq_no": 3, "_primary_term": 1, "policy": {}}`,
+			want: []request{
+				{method: "GET", path: "/_plugins/_ism/policies/logs"},
+				{method: "PUT", path: "/_plugins/_ism/policies/logs", query: "if_primary_term=1&if_seq_no=3"},
+			},
+		},
+		{
+			name: "Create",
+			want: []request{
+				{method: "GET", path: "/_plugins/_ism/policies/logs"},
+				{method: "PUT", path: "/_plugins/_ism/policies/logs"},
+			},
+		},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			var mu sync.Mutex
+			got := []request{}
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case r.URL.Path == "/":
+					fmt.Fprintln(w, `{"version": {"number": "2.11.0", "distribution": "opensearch"}}`)
+					return
+				case r.Method == http.MethodGet && inOut.policy == "":
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprintln(w, `{"error": "not found"}`)
+				case r.Method == http.MethodGet:
+					fmt.Fprintln(w, inOut.policy)
+				default:
+					fmt.Fprintln(w, `{"_id": "logs"}`)
+				}
+				got = append(got, request{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery})
+			}))
+			defer ts.Close()
+
+			baseClient, _ := es.NewBaseClient(config.Config{Host: ts.URL}, ts.Client())
+			err := baseClient.PutLifecyclePolicy(context.Background(), "logs", `{"states": []}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(inOut.want, got, cmp.AllowUnexported(request{})); diff != "" {
+				t.Errorf("Not mutch requests, diff(-want, +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestListStateManagementPolicies(t *testing.T) {
+	t.Parallel()
+	var query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintln(w, `{"version": {"number": "2.11.0", "distribution": "opensearch"}}`)
+		case "/_plugins/_ism/policies":
+			query = r.URL.RawQuery
+			fmt.Fprintln(w, `{"policies": [{"_id": "logs", "_seq_no": 3, "_primary_term": 1, "policy": {"policy_id": "logs", "last_updated_time": 1700000000000, "schema_version": 19, "description": "d", "states": []}}], "total_policies": 1}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	baseClient, _ := es.NewBaseClient(config.Config{Host: ts.URL}, ts.Client())
+	policies, err := baseClient.ListLifecyclePolicies(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fields which are set by OpenSearch are removed, so that the policy can be put as is
+	got := map[string]string{}
+	for name, policy := range policies {
+		got[name] = string(policy)
+	}
+	want := map[string]string{"logs": `{"description":"d","states":[]}`}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Not mutch policies, diff(-want, +got) %s", diff)
+	}
+	if query != "size=10000" {
+		t.Errorf("Not mutch query, got %s", query)
+	}
+}
+
+func TestHitsTotal(t *testing.T) {
+	t.Parallel()
+
+	type InOutPairs struct {
+		name     string
+		in       string
+		want     es.HitsTotal
+		hasError bool
+	}
+	inOutPairs := []InOutPairs{
+		{name: "6.x", in: `{"total": 12}`, want: 12},
+		{name: "7.x", in: `{"total": {"value": 10000, "relation": "gte"}}`, want: 10000},
+		{name: "Invalid", in: `{"total": "12"}`, hasError: true},
+	}
+
+	for _, inOut := range inOutPairs {
+		inOut := inOut
+		t.Run(inOut.name, func(t *testing.T) {
+			t.Parallel()
+			got := struct {
+				Total es.HitsTotal `json:"total"`
+			}{}
+			err := json.Unmarshal([]byte(inOut.in), &got)
+			if (err != nil) != inOut.hasError {
+				t.Fatalf("hasError want %v, got %v", inOut.hasError, err)
+			}
+			if got.Total != inOut.want {
+				t.Errorf("want %d, got %d", inOut.want, got.Total)
+			}
+		})
+	}
+}
+
+func TestServerInfoRetry(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	versionRequests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		versionRequests++
+		if versionRequests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, `{"error": "starting"}`)
+			return
+		}
+		fmt.Fprintln(w, `{"version": {"number": "7.17.0"}}`)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	baseClient, _ := es.NewBaseClient(config.Config{Host: ts.URL}, ts.Client())
+	if _, err := baseClient.ServerInfo(ctx); err == nil {
+		t.Fatal("Expected error")
+	}
+	// The error is not cached, and the successful result is cached
+	for n := 0; n < 2; n++ {
+		info, err := baseClient.ServerInfo(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Version.Number != "7.17.0" {
+			t.Errorf("Not mutch version, got %s", info.Version.Number)
+		}
+	}
+	if versionRequests != 2 {
+		t.Errorf("Version is requested %d times", versionRequests)
+	}
+}
+
+func TestAuthError(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "Unauthorized")
+	}))
+	defer ts.Close()
+
+	baseClient, _ := es.NewBaseClient(config.Config{Host: ts.URL}, ts.Client())
+	_, err := baseClient.ListIndex(context.Background())
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	want := "401 Unauthorized: Unauthorized (Authentication failed. Check --user and --pass)"
+	if err.Error() != want {
+		t.Errorf("Not mutch error, want %q, got %q", want, err.Error())
+	}
+	if es.IsNotFound(err) {
+		t.Error("Unauthorized is not not found")
+	}
+}